@@ -0,0 +1,540 @@
+package api
+
+//
+// a Redis-compatible listener, speaking RESP2, or RESP3 after HELLO 3, so that redis-cli
+// and the Redis client libraries work against DDB. it maps
+//   GET, SET [EX s|PX ms], APPEND, DEL, EXISTS, INCR, MGET, MSET, SCAN and EXPIRE
+// onto the replicated ops, along with PING, ECHO, HELLO, AUTH, SELECT 0 and QUIT.
+// a follower proxies the ops to the leader, so that clients may connect to any server.
+// in a sharded deployment, a server only serves the keys of its own group: it doesn't speak
+// Redis Cluster, hence sends no MOVED, so the clients must go through a proxy that routes
+// the keys to the groups, e.g. one built on the clerk.
+// the multi-key commands are made of one op per key, hence aren't atomic.
+// SCAN only returns the keys of string values, in key order, its cursor is an offset,
+// and its MATCH patterns support * and ? only.
+//
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"DDB/kvraft"
+	"DDB/secure"
+)
+
+// proxyTimeout bounds an op proxied to the leader.
+const proxyTimeout = 1 * time.Second
+
+// the limits of a command, so that a client can't make the server allocate more than it
+// sends. a bulk string is at most the size of an AppendEntries, and an inline command
+// at most the size of the read buffer.
+const (
+	maxArgs     = 1 << 20
+	maxBulkSize = 1 << 20
+	bulkChunk   = 64 << 10 // the most of a bulk string allocated ahead of its bytes.
+)
+
+var respErrors = map[kvraft.Err]string{
+	kvraft.ErrWrongType:        "WRONGTYPE Operation against a key holding the wrong kind of value",
+	kvraft.ErrNotInteger:       "ERR value is not an integer or out of range",
+	kvraft.ErrWrongLeader:      "TRYAGAIN no leader",
+	kvraft.ErrNotApplied:       "TRYAGAIN the op was not applied in time",
+	kvraft.ErrUnauthenticated:  "NOAUTH Authentication required",
+	kvraft.ErrPermissionDenied: "NOPERM no permission to access the key",
+	kvraft.ErrWrongGroup:       "ERR the key is served by another replica group",
+}
+
+var errSyntax = errors.New("ERR syntax error")
+
+// the min number of arguments of the commands.
+var arity = map[string]int{
+	"GET": 1, "SET": 2, "APPEND": 2, "DEL": 1, "EXISTS": 1, "INCR": 1,
+	"MGET": 1, "MSET": 2, "SCAN": 1, "EXPIRE": 2, "ECHO": 1, "AUTH": 1, "SELECT": 1,
+}
+
+type respServer struct {
+	kv *kvraft.KVServer
+}
+
+// respConn is the state of a client connection.
+type respConn struct {
+	r     *bufio.Reader
+	w     *bufio.Writer
+	proto int // 2 or 3.
+	token string
+}
+
+func (c *respConn) simple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) error(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *respConn) integer(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) bulk(s string) {
+	c.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (c *respConn) null() {
+	if c.proto == 3 {
+		c.w.WriteString("_\r\n")
+	} else {
+		c.w.WriteString("$-1\r\n")
+	}
+}
+
+func (c *respConn) array(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// dict starts a map of n pairs, which is a flat array in RESP2.
+func (c *respConn) dict(n int) {
+	if c.proto == 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		c.array(2 * n)
+	}
+}
+
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("ERR Protocol error: too big inline request")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readCommand reads a command, either as an array of bulk strings or inline.
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errors.New("ERR Protocol error: invalid multibulk length")
+	}
+	args := []string{}
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if !strings.HasPrefix(line, "$") || err != nil || size < 0 || size > maxBulkSize {
+			return nil, errors.New("ERR Protocol error: invalid bulk length")
+		}
+		// read in chunks, so that the buffer only grows as the bytes arrive.
+		ahead := size + 2
+		if ahead > bulkChunk {
+			ahead = bulkChunk
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, ahead))
+		if _, err := io.CopyN(buf, c.r, int64(size+2)); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\r\n")) {
+			return nil, errors.New("ERR Protocol error: invalid bulk string")
+		}
+		args = append(args, string(buf.Bytes()[:size]))
+	}
+	return args, nil
+}
+
+// do runs a command op on this server, proxying it to the leader if this server isn't.
+func (s *respServer) do(c *respConn, args *kvraft.CommandArgs) ([]string, kvraft.Err) {
+	args.Token = c.token
+	reply := kvraft.CommandReply{}
+	s.kv.Command(args, &reply)
+	if reply.Err != kvraft.ErrWrongLeader {
+		return reply.Values, reply.Err
+	}
+	// a fresh reply, as an abandoned call may still write it.
+	proxied := &kvraft.CommandReply{}
+	if !toLeader(s.kv, "KVServer.Command", args, proxied) {
+		return nil, kvraft.ErrWrongLeader
+	}
+	return proxied.Values, proxied.Err
+}
+
+// toLeader makes the call rpcname, that kv refused as it isn't the leader, to the leader.
+// it returns false if kv doesn't know the leader, or the leader can't be reached.
+func toLeader(kv *kvraft.KVServer, rpcname string, args interface{}, reply interface{}) bool {
+	leader, ok := kv.Raft().Leader()
+	if !ok {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyTimeout)
+	defer cancel()
+	return leader.CallContext(ctx, rpcname, args, reply)
+}
+
+// first returns the first value of a reply, or "" if there's none.
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// fail writes err as a Redis error, and tells whether there was one.
+func (c *respConn) fail(err kvraft.Err) bool {
+	if err == kvraft.OK {
+		return false
+	}
+	if s, ok := respErrors[err]; ok {
+		c.error(s)
+	} else {
+		c.error("ERR " + string(err))
+	}
+	return true
+}
+
+func (s *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &respConn{r: bufio.NewReader(conn), w: bufio.NewWriter(conn), proto: 2}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				c.error(err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.command(c, strings.ToUpper(args[0]), args[1:])
+		if c.w.Flush() != nil || quit {
+			return
+		}
+	}
+}
+
+// command runs a command and writes its reply. it returns true once the client quits.
+func (s *respServer) command(c *respConn, name string, args []string) bool {
+	if len(args) < arity[name] {
+		c.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return false
+	}
+
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			c.bulk(args[0])
+		} else {
+			c.simple("PONG")
+		}
+
+	case "ECHO":
+		c.bulk(args[0])
+
+	case "QUIT":
+		c.simple("OK")
+		return true
+
+	case "SELECT":
+		if args[0] != "0" {
+			c.error("ERR DB index is out of range")
+		} else {
+			c.simple("OK")
+		}
+
+	case "COMMAND":
+		c.array(0)
+
+	case "CLIENT":
+		c.simple("OK")
+
+	case "AUTH":
+		// note: the token is only checked by the ops that follow.
+		user, secret := "default", args[0]
+		if len(args) > 1 {
+			user, secret = args[0], args[1]
+		}
+		c.token = user + ":" + secret
+		c.simple("OK")
+
+	case "HELLO":
+		s.hello(c, args)
+
+	case "GET":
+		values, err := s.do(c, &kvraft.CommandArgs{Op: "Get", Key: args[0]})
+		if err == kvraft.ErrNoKey {
+			c.null()
+		} else if !c.fail(err) {
+			c.bulk(first(values))
+		}
+
+	case "SET":
+		s.set(c, args)
+
+	case "APPEND":
+		values, err := s.do(c, &kvraft.CommandArgs{Op: "Append", Key: args[0], Value: args[1]})
+		if !c.fail(err) {
+			n, _ := strconv.ParseInt(first(values), 10, 64)
+			c.integer(n)
+		}
+
+	case "DEL", "EXISTS":
+		op := map[string]string{"DEL": "Delete", "EXISTS": "Exists"}[name]
+		count := int64(0)
+		for _, key := range args {
+			values, err := s.do(c, &kvraft.CommandArgs{Op: op, Key: key})
+			if c.fail(err) {
+				return false
+			}
+			if first(values) == "1" {
+				count++
+			}
+		}
+		c.integer(count)
+
+	case "INCR":
+		values, err := s.do(c, &kvraft.CommandArgs{Op: "Incr", Key: args[0], Value: "1"})
+		if !c.fail(err) {
+			n, _ := strconv.ParseInt(first(values), 10, 64)
+			c.integer(n)
+		}
+
+	case "MGET":
+		results := make([]*string, len(args))
+		for i, key := range args {
+			values, err := s.do(c, &kvraft.CommandArgs{Op: "Get", Key: key})
+			if err == kvraft.ErrWrongType || err == kvraft.ErrNoKey {
+				continue
+			}
+			if c.fail(err) {
+				return false
+			}
+			value := first(values)
+			results[i] = &value
+		}
+		c.array(len(results))
+		for _, value := range results {
+			if value == nil {
+				c.null()
+			} else {
+				c.bulk(*value)
+			}
+		}
+
+	case "MSET":
+		if len(args)%2 != 0 {
+			c.error("ERR wrong number of arguments for 'mset' command")
+			return false
+		}
+		for i := 0; i < len(args); i += 2 {
+			if _, err := s.do(c, &kvraft.CommandArgs{Op: "Put", Key: args[i], Value: args[i+1]}); c.fail(err) {
+				return false
+			}
+		}
+		c.simple("OK")
+
+	case "EXPIRE":
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.fail(kvraft.ErrNotInteger)
+			return false
+		}
+		values, e := s.do(c, &kvraft.CommandArgs{Op: "Expire", Key: args[0], Value: strconv.FormatInt(seconds*1000, 10)})
+		if !c.fail(e) {
+			n, _ := strconv.ParseInt(first(values), 10, 64)
+			c.integer(n)
+		}
+
+	case "SCAN":
+		s.scan(c, args)
+
+	default:
+		c.error("ERR unknown command '" + strings.ToLower(name) + "'")
+	}
+	return false
+}
+
+// hello switches the protocol version, as in HELLO [protover [AUTH user secret] [SETNAME name]].
+func (s *respServer) hello(c *respConn, args []string) {
+	proto := c.proto
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || (v != 2 && v != 3) {
+			c.error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+	}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				c.error(errSyntax.Error())
+				return
+			}
+			c.token = args[i+1] + ":" + args[i+2]
+			i += 2
+		case "SETNAME":
+			i++
+		}
+	}
+	c.proto = proto
+	c.dict(7)
+	c.bulk("server")
+	c.bulk("ddb")
+	c.bulk("version")
+	c.bulk("1.0.0")
+	c.bulk("proto")
+	c.integer(int64(c.proto))
+	c.bulk("id")
+	c.integer(1)
+	c.bulk("mode")
+	c.bulk("standalone")
+	c.bulk("role")
+	c.bulk("master")
+	c.bulk("modules")
+	c.array(0)
+}
+
+// set runs SET key value [EX seconds | PX milliseconds].
+func (s *respServer) set(c *respConn, args []string) {
+	ttl := int64(0)
+	if len(args) > 2 {
+		if len(args) != 4 {
+			c.error(errSyntax.Error())
+			return
+		}
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 {
+			c.error("ERR invalid expire time in 'set' command")
+			return
+		}
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			ttl = n * 1000
+		case "PX":
+			ttl = n
+		default:
+			c.error(errSyntax.Error())
+			return
+		}
+	}
+	// note: a single op, so that the value never lives without its expiry.
+	if _, err := s.do(c, &kvraft.CommandArgs{Op: "Put", Key: args[0], Value: args[1], TTL: ttl}); c.fail(err) {
+		return
+	}
+	c.simple("OK")
+}
+
+// scan runs SCAN cursor [MATCH pattern] [COUNT count], where the cursor is the number of
+// keys already scanned.
+func (s *respServer) scan(c *respConn, args []string) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		c.error("ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", 10
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				c.error(errSyntax.Error())
+				return
+			}
+		}
+	}
+	// only the keys starting with the literal prefix of the pattern need to be scanned.
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		prefix = pattern[:i]
+	}
+	values, e := s.do(c, &kvraft.CommandArgs{Op: "Scan", Key: prefix, Limit: cursor + count})
+	if c.fail(e) {
+		return
+	}
+	keys := []string{}
+	scanned := 0
+	for i := 0; i+1 < len(values); i += 2 {
+		scanned++
+		if scanned <= cursor {
+			continue
+		}
+		if match(pattern, values[i]) {
+			keys = append(keys, values[i])
+		}
+	}
+	next := "0"
+	if scanned == cursor+count {
+		next = strconv.Itoa(cursor + count)
+	}
+	c.array(2)
+	c.bulk(next)
+	c.array(len(keys))
+	for _, key := range keys {
+		c.bulk(key)
+	}
+}
+
+// match tells whether s matches the glob pattern, where * matches any string and ? any byte.
+func match(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// ServeRESP serves the Redis protocol for kv on port, over TLS once secure.Setup has been called.
+func ServeRESP(kv *kvraft.KVServer, port string) error {
+	l, err := secure.Listen(port)
+	if err != nil {
+		return err
+	}
+	s := &respServer{kv}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
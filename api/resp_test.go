@@ -0,0 +1,35 @@
+package api
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string // nil for an error.
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", []string{"GET", "a"}},
+		{"GET a\r\n", []string{"GET", "a"}},
+		{"*1\r\n$-1\r\n", nil},
+		{"*1\r\n$3\r\nGETX\r\n", nil},
+		// a bulk string past the limit is refused before anything is read or allocated.
+		{"*1\r\n$536870912\r\n", nil},
+		// one within the limit is only read as far as it's sent.
+		{"*1\r\n$1048576\r\nabc", nil},
+		{strings.Repeat("a", 8192) + "\r\n", nil},
+	}
+	for _, test := range tests {
+		c := &respConn{r: bufio.NewReader(strings.NewReader(test.in))}
+		args, err := c.readCommand()
+		if test.want == nil && err == nil {
+			t.Errorf("readCommand(%.40q) = %q, want an error", test.in, args)
+		}
+		if test.want != nil && (err != nil || !reflect.DeepEqual(args, test.want)) {
+			t.Errorf("readCommand(%.40q) = %q, %v, want %q", test.in, args, err, test.want)
+		}
+	}
+}
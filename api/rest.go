@@ -0,0 +1,242 @@
+package api
+
+//
+// the REST API of a KV server, with JSON bodies:
+//   GET    /v1/kv/{key}          -> {"key": key, "value": value}
+//   PUT    /v1/kv/{key}          <- {"value": value}
+//   POST   /v1/kv/{key}:append   <- {"value": value}
+//   DELETE /v1/kv/{key}
+//   GET    /v1/kv?prefix=p&limit=n -> {"kvs": [{"key": key, "value": value}, ...]}
+//   POST   /v1/sessions          -> {"client_id": id}
+//   POST   /v1/sessions/{id}:keepalive
+// a write body may also carry "client_id" and "op_id", with the meaning of the gRPC Session.
+// errors come as {"error": err}. a server that isn't the leader proxies the request to the
+// leader, as the RESP gateway does. it answers 503 if it can't, upon which the client
+// retries on another server.
+// with authentication on, the user and secret go in basic auth, e.g. curl -u user:secret.
+//
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"DDB/kvraft"
+	"DDB/secure"
+)
+
+const (
+	kvPath      = "/v1/kv"
+	sessionPath = "/v1/sessions"
+)
+
+var httpCodes = map[kvraft.Err]int{
+	kvraft.ErrNoKey:            http.StatusNotFound,
+	kvraft.ErrWrongLeader:      http.StatusServiceUnavailable,
+	kvraft.ErrWrongGroup:       http.StatusServiceUnavailable,
+	kvraft.ErrNotReady:         http.StatusServiceUnavailable,
+	kvraft.ErrNotApplied:       http.StatusServiceUnavailable,
+	kvraft.ErrWrongType:        http.StatusConflict,
+	kvraft.ErrNotInteger:       http.StatusConflict,
+	kvraft.ErrUnknownOp:        http.StatusBadRequest,
+	kvraft.ErrUnauthenticated:  http.StatusUnauthorized,
+	kvraft.ErrPermissionDenied: http.StatusForbidden,
+	kvraft.ErrSessionExpired:   http.StatusGone,
+}
+
+type writeBody struct {
+	Value    string `json:"value"`
+	ClientId int64  `json:"client_id"`
+	OpId     int64  `json:"op_id"`
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type scanBody struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+type sessionBody struct {
+	ClientId int64 `json:"client_id"`
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+type restServer struct {
+	kv *kvraft.KVServer
+}
+
+func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if user, secret, ok := r.BasicAuth(); ok {
+		token = user + ":" + secret
+	}
+
+	if r.URL.Path == kvPath && r.Method == http.MethodGet {
+		s.scan(w, r, token)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, sessionPath) && r.Method == http.MethodPost {
+		s.session(w, r, token)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, kvPath+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		args := kvraft.GetArgs{Key: key, Token: token}
+		reply := kvraft.GetReply{}
+		s.kv.Get(&args, &reply)
+		if proxied := (&kvraft.GetReply{}); reply.Err == kvraft.ErrWrongLeader &&
+			toLeader(s.kv, "KVServer.Get", &args, proxied) {
+			reply = *proxied
+		}
+		if s.failed(w, reply.Err) {
+			return
+		}
+		writeJSON(w, http.StatusOK, keyValue{key, reply.Value})
+
+	case http.MethodPut, http.MethodDelete, http.MethodPost:
+		op := "Put"
+		if r.Method == http.MethodDelete {
+			op = "Delete"
+		} else if r.Method == http.MethodPost {
+			if key, ok = strings.CutSuffix(key, ":append"); !ok {
+				http.NotFound(w, r)
+				return
+			}
+			op = "Append"
+		}
+		body := writeBody{}
+		if r.Method != http.MethodDelete {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorBody{err.Error()})
+				return
+			}
+		}
+		args := kvraft.PutAppendArgs{Key: key, Value: body.Value, Op: op, Token: token}
+		args.ClerkId, args.OpId = session(&Session{ClientId: body.ClientId, OpId: body.OpId})
+		args.Acked = args.OpId
+		reply := kvraft.PutAppendReply{}
+		s.kv.PutAppend(&args, &reply)
+		if proxied := (&kvraft.PutAppendReply{}); reply.Err == kvraft.ErrWrongLeader &&
+			toLeader(s.kv, "KVServer.PutAppend", &args, proxied) {
+			reply = *proxied
+		}
+		if s.failed(w, reply.Err) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody{"method not allowed"})
+	}
+}
+
+func (s *restServer) scan(w http.ResponseWriter, r *http.Request, token string) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{"invalid limit"})
+			return
+		}
+	}
+	args := kvraft.CommandArgs{Op: "Scan", Key: r.URL.Query().Get("prefix"), Limit: limit, Token: token}
+	reply := s.command(&args)
+	if s.failed(w, reply.Err) {
+		return
+	}
+	body := scanBody{Kvs: []keyValue{}}
+	for i := 0; i+1 < len(reply.Values); i += 2 {
+		body.Kvs = append(body.Kvs, keyValue{reply.Values[i], reply.Values[i+1]})
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// session registers a client, or keeps its session alive.
+func (s *restServer) session(w http.ResponseWriter, r *http.Request, token string) {
+	args := kvraft.CommandArgs{Op: "RegisterClient", Token: token}
+	if r.URL.Path != sessionPath {
+		id, prefixed := strings.CutPrefix(r.URL.Path, sessionPath+"/")
+		id, suffixed := strings.CutSuffix(id, ":keepalive")
+		if !prefixed || !suffixed {
+			http.NotFound(w, r)
+			return
+		}
+		var err error
+		if args.ClerkId, err = strconv.ParseInt(id, 10, 64); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{"invalid client id"})
+			return
+		}
+		args.Op = "KeepAlive"
+	}
+	reply := s.command(&args)
+	if s.failed(w, reply.Err) {
+		return
+	}
+	if args.Op == "KeepAlive" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, _ := strconv.ParseInt(reply.Values[0], 10, 64)
+	writeJSON(w, http.StatusOK, sessionBody{id})
+}
+
+// command runs a command op on this server, or on the leader if this server isn't.
+func (s *restServer) command(args *kvraft.CommandArgs) kvraft.CommandReply {
+	reply := kvraft.CommandReply{}
+	s.kv.Command(args, &reply)
+	// note: a fresh reply for the leader, as an abandoned call may still write it.
+	if proxied := (&kvraft.CommandReply{}); reply.Err == kvraft.ErrWrongLeader &&
+		toLeader(s.kv, "KVServer.Command", args, proxied) {
+		reply = *proxied
+	}
+	return reply
+}
+
+// failed writes the error response for err, if it's not OK.
+func (s *restServer) failed(w http.ResponseWriter, err kvraft.Err) bool {
+	if err == kvraft.OK {
+		return false
+	}
+	code, ok := httpCodes[err]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, errorBody{string(err)})
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ServeREST serves the REST API of kv on port, over TLS once secure.Setup has been called.
+func ServeREST(kv *kvraft.KVServer, port string) error {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	s := &http.Server{Handler: &restServer{kv}, TLSConfig: secure.ServerConfig()}
+	if s.TLSConfig != nil {
+		go s.ServeTLS(l, "", "")
+	} else {
+		go s.Serve(l)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"DDB/kvraft"
+)
+
+// a follower serves the REST API by proxying the requests to the leader.
+func TestRESTOnFollower(t *testing.T) {
+	kvs := startGroup(t, 3)
+
+	// a follower that has heard from the leader.
+	var follower *kvraft.KVServer
+	for start := time.Now(); follower == nil; time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("no leader elected")
+		}
+		for _, kv := range kvs {
+			if _, isLeader := kv.Raft().GetState(); !isLeader {
+				if _, ok := kv.Raft().Leader(); ok {
+					follower = kv
+				}
+			}
+		}
+	}
+	ts := httptest.NewServer(&restServer{follower})
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+kvPath+"/a", strings.NewReader(`{"value": "1"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT on a follower = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp, err = http.Get(ts.URL + kvPath + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := keyValue{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Value != "1" {
+		t.Fatalf("GET on a follower = %d %+v, want %d with the value 1", resp.StatusCode, body, http.StatusOK)
+	}
+}
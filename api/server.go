@@ -0,0 +1,186 @@
+package api
+
+//
+// the gRPC API of a KV server, defined in ddb.proto, for clients in other languages.
+// regenerate the code with protoc-gen-go and protoc-gen-go-grpc, e.g.
+//   buf generate, or
+//   protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ddb.proto
+// the requests go through the same handlers, hence the same raft log, as the net/rpc ones.
+//
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"DDB/kvraft"
+	"DDB/secure"
+)
+
+var statusCodes = map[kvraft.Err]codes.Code{
+	kvraft.ErrNoKey:            codes.NotFound,
+	kvraft.ErrWrongLeader:      codes.Unavailable,
+	kvraft.ErrWrongGroup:       codes.Unavailable,
+	kvraft.ErrNotReady:         codes.Unavailable,
+	kvraft.ErrNotApplied:       codes.Unavailable,
+	kvraft.ErrWrongType:        codes.FailedPrecondition,
+	kvraft.ErrNotInteger:       codes.FailedPrecondition,
+	kvraft.ErrUnknownOp:        codes.Unimplemented,
+	kvraft.ErrUnauthenticated:  codes.Unauthenticated,
+	kvraft.ErrPermissionDenied: codes.PermissionDenied,
+	kvraft.ErrSessionExpired:   codes.Aborted,
+}
+
+// toStatus converts err into a gRPC status, whose message is err itself.
+func toStatus(err kvraft.Err) error {
+	if err == kvraft.OK {
+		return nil
+	}
+	code, ok := statusCodes[err]
+	if !ok {
+		code = codes.Unknown
+	}
+	return status.Error(code, string(err))
+}
+
+// token returns the "user:secret" token of the call, from its "authorization" metadata.
+func token(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// session returns the clerk id and op id of a write, which are 0 without a session.
+// a session has one write in flight at a time, so that each write acks the previous ones.
+func session(s *Session) (int64, int) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.ClientId, int(s.OpId)
+}
+
+type server struct {
+	UnimplementedKVServer
+	kv *kvraft.KVServer
+}
+
+func (s *server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	args := kvraft.GetArgs{Key: req.Key, Token: token(ctx)}
+	reply := kvraft.GetReply{}
+	s.kv.Get(&args, &reply)
+	if reply.Err != kvraft.OK {
+		return nil, toStatus(reply.Err)
+	}
+	return &GetResponse{Value: reply.Value}, nil
+}
+
+func (s *server) putAppend(ctx context.Context, op string, key string, value string, sess *Session) error {
+	args := kvraft.PutAppendArgs{Key: key, Value: value, Op: op, Token: token(ctx)}
+	args.ClerkId, args.OpId = session(sess)
+	args.Acked = args.OpId
+	reply := kvraft.PutAppendReply{}
+	s.kv.PutAppend(&args, &reply)
+	return toStatus(reply.Err)
+}
+
+func (s *server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	if err := s.putAppend(ctx, "Put", req.Key, req.Value, req.Session); err != nil {
+		return nil, err
+	}
+	return &PutResponse{}, nil
+}
+
+func (s *server) Append(ctx context.Context, req *AppendRequest) (*AppendResponse, error) {
+	if err := s.putAppend(ctx, "Append", req.Key, req.Value, req.Session); err != nil {
+		return nil, err
+	}
+	return &AppendResponse{}, nil
+}
+
+func (s *server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.putAppend(ctx, "Delete", req.Key, "", req.Session); err != nil {
+		return nil, err
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *server) RegisterClient(ctx context.Context, req *RegisterClientRequest) (*RegisterClientResponse, error) {
+	args := kvraft.CommandArgs{Op: "RegisterClient", Token: token(ctx)}
+	reply := kvraft.CommandReply{}
+	s.kv.Command(&args, &reply)
+	if reply.Err != kvraft.OK {
+		return nil, toStatus(reply.Err)
+	}
+	id, _ := strconv.ParseInt(reply.Values[0], 10, 64)
+	return &RegisterClientResponse{ClientId: id}, nil
+}
+
+func (s *server) KeepAlive(ctx context.Context, req *KeepAliveRequest) (*KeepAliveResponse, error) {
+	args := kvraft.CommandArgs{Op: "KeepAlive", ClerkId: req.ClientId, Token: token(ctx)}
+	reply := kvraft.CommandReply{}
+	s.kv.Command(&args, &reply)
+	if reply.Err != kvraft.OK {
+		return nil, toStatus(reply.Err)
+	}
+	return &KeepAliveResponse{}, nil
+}
+
+func (s *server) Scan(ctx context.Context, req *ScanRequest) (*ScanResponse, error) {
+	args := kvraft.CommandArgs{Op: "Scan", Key: req.Prefix, Limit: int(req.Limit), Token: token(ctx)}
+	reply := kvraft.CommandReply{}
+	s.kv.Command(&args, &reply)
+	if reply.Err != kvraft.OK {
+		return nil, toStatus(reply.Err)
+	}
+	resp := &ScanResponse{}
+	for i := 0; i+1 < len(reply.Values); i += 2 {
+		resp.Kvs = append(resp.Kvs, &KeyValue{Key: reply.Values[i], Value: reply.Values[i+1]})
+	}
+	return resp, nil
+}
+
+func (s *server) Watch(req *WatchRequest, stream KV_WatchServer) error {
+	events, cancel, err := s.kv.Watch(token(stream.Context()), req.Prefix)
+	if err != kvraft.OK {
+		return toStatus(err)
+	}
+	defer cancel()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind")
+			}
+			e := &WatchEvent{Key: event.Key, Op: event.Op, Value: event.Value, Deleted: event.Deleted}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Serve serves the gRPC API of kv on port, over TLS once secure.Setup has been called.
+func Serve(kv *kvraft.KVServer, port string) error {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{}
+	if config := secure.ServerConfig(); config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	s := grpc.NewServer(opts...)
+	RegisterKVServer(s, &server{kv: kv})
+	go s.Serve(l)
+	return nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"DDB/client"
+	"DDB/kvraft"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+// startGroup starts a group of n servers on a simulated network.
+func startGroup(t *testing.T, n int) []*kvraft.KVServer {
+	net := transport.MakeNetwork()
+	servers := []*client.Client{}
+	for i := 0; i < n; i++ {
+		servers = append(servers, net.Dial(fmt.Sprint("s", i)))
+	}
+	config := raft.DefaultConfig
+	config.MaxRaftState = -1
+	kvs := []*kvraft.KVServer{}
+	for i := range servers {
+		name := fmt.Sprint("s", i)
+		kvs = append(kvs, kvraft.StartShardKVServerOn(net.From(name), servers, i, raft.MakePersister(), config, name, 0, nil))
+	}
+	t.Cleanup(func() {
+		for _, kv := range kvs {
+			kv.Kill()
+		}
+	})
+	return kvs
+}
+
+// leader waits for a leader among kvs, and returns it.
+func leader(t *testing.T, kvs []*kvraft.KVServer) *kvraft.KVServer {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		for _, kv := range kvs {
+			if _, isLeader := kv.Raft().GetState(); isLeader {
+				return kv
+			}
+		}
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// the requests without a session go with clerk id 0: each of them is applied, with no
+// dedup window kept for it, so that the same write sent twice is applied twice.
+func TestWithoutSession(t *testing.T) {
+	s := &server{kv: leader(t, startGroup(t, 3))}
+	ctx := context.Background()
+
+	if _, err := s.Put(ctx, &PutRequest{Key: "a", Value: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Append(ctx, &AppendRequest{Key: "a", Value: "y"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := s.Get(ctx, &GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != "xyy" {
+		t.Fatalf("Get(a) = %q, want %q", resp.Value, "xyy")
+	}
+}
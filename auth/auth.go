@@ -0,0 +1,181 @@
+package auth
+
+//
+// users, roles and permissions. a Store is replicated by whichever service keeps it:
+// an unsharded kvraft group applies the auth ops to its own store, while in a sharded
+// deployment the shard controller keeps the store, and each config carries the one in
+// force, so that every group enforces the same users, including those joining later.
+//   a user: the sha256 of its secret, and the roles granted to it.
+//   a role: a permission for each of some key prefixes.
+// a client authenticates with the token "name:secret".
+//
+// authentication is off as long as no user has been granted a role, so that the first
+// users and roles can be set up.
+// managing users and roles takes the admin permission on the empty prefix, i.e. on all keys.
+//
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+type Permission int
+
+const (
+	NoPermission Permission = iota
+	Read
+	Write // implies Read.
+	Admin // implies Write.
+)
+
+var Permissions = map[string]Permission{"read": Read, "write": Write, "admin": Admin}
+
+var (
+	ErrUnauthenticated   = errors.New("unknown user or wrong secret")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrNoUser            = errors.New("no such user")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+type User struct {
+	Secret string          // the sha256 of the secret.
+	Roles  map[string]bool // the roles granted.
+}
+
+type Store struct {
+	Users map[string]*User
+	Roles map[string]map[string]Permission // role -> key prefix -> permission.
+
+	// whether authentication is on, i.e. some user has a role. kept up to date
+	// by Apply, so that requests needn't look at every user.
+	Enabled bool
+}
+
+func MakeStore() *Store {
+	return &Store{Users: map[string]*User{}, Roles: map[string]map[string]Permission{}}
+}
+
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsOp(op string) bool {
+	switch op {
+	case "AddUser", "DeleteUser", "GrantRole", "RevokeRole", "SetPermission":
+		return true
+	}
+	return false
+}
+
+// Copy returns a deep copy of s.
+func (s *Store) Copy() *Store {
+	copied := MakeStore()
+	for name, user := range s.Users {
+		roles := map[string]bool{}
+		for role := range user.Roles {
+			roles[role] = true
+		}
+		copied.Users[name] = &User{Secret: user.Secret, Roles: roles}
+	}
+	for role, prefixes := range s.Roles {
+		copied.Roles[role] = map[string]Permission{}
+		for prefix, p := range prefixes {
+			copied.Roles[role][prefix] = p
+		}
+	}
+	copied.Enabled = s.Enabled
+	return copied
+}
+
+// Apply applies an auth op. name is the user, or the role for SetPermission; field is the
+// role for GrantRole and RevokeRole, or the key prefix for SetPermission; value is the hash
+// of the secret for AddUser, or the permission for SetPermission, empty to revoke it.
+func (s *Store) Apply(op string, name string, field string, value string) error {
+	// note: gob decodes empty maps as nil.
+	if s.Users == nil {
+		s.Users = map[string]*User{}
+	}
+	if s.Roles == nil {
+		s.Roles = map[string]map[string]Permission{}
+	}
+	switch op {
+	case "AddUser":
+		if user, ok := s.Users[name]; ok {
+			user.Secret = value
+		} else {
+			s.Users[name] = &User{Secret: value, Roles: map[string]bool{}}
+		}
+
+	case "DeleteUser":
+		delete(s.Users, name)
+
+	case "GrantRole":
+		user, ok := s.Users[name]
+		if !ok {
+			return ErrNoUser
+		}
+		if user.Roles == nil {
+			user.Roles = map[string]bool{}
+		}
+		user.Roles[field] = true
+
+	case "RevokeRole":
+		if user, ok := s.Users[name]; ok {
+			delete(user.Roles, field)
+		}
+
+	case "SetPermission":
+		p, ok := Permissions[value]
+		if !ok && value != "" {
+			return ErrUnknownPermission
+		}
+		if value == "" {
+			delete(s.Roles[name], field)
+			if len(s.Roles[name]) == 0 {
+				delete(s.Roles, name)
+			}
+		} else {
+			if s.Roles[name] == nil {
+				s.Roles[name] = map[string]Permission{}
+			}
+			s.Roles[name][field] = p
+		}
+	}
+
+	s.Enabled = false
+	for _, user := range s.Users {
+		s.Enabled = s.Enabled || len(user.Roles) > 0
+	}
+	return nil
+}
+
+// Authorize checks that the holder of token has the permission perm on key.
+func (s *Store) Authorize(token string, key string, perm Permission) error {
+	if !s.Enabled {
+		return nil
+	}
+
+	name, secret, _ := strings.Cut(token, ":")
+	user, ok := s.Users[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.Secret), []byte(HashSecret(secret))) != 1 {
+		return ErrUnauthenticated
+	}
+
+	// the longest prefix of key granted to any role of the user decides.
+	granted, longest := NoPermission, -1
+	for role := range user.Roles {
+		for prefix, p := range s.Roles[role] {
+			if strings.HasPrefix(key, prefix) && (len(prefix) > longest || (len(prefix) == longest && p > granted)) {
+				granted, longest = p, len(prefix)
+			}
+		}
+	}
+	if granted < perm {
+		return ErrPermissionDenied
+	}
+	return nil
+}
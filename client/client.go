@@ -1,12 +1,21 @@
 package client
 
 import (
-	"net/rpc"
+	"context"
+	"net"
 )
 
 type Client struct {
 	Ip   string
 	Port string
+
+	// caller carries the RPCs of the client. it's nil for TCP, and isn't sent over the wire.
+	caller Caller
+}
+
+// Caller is a transport other than TCP that RPCs can be sent over, such as a simulated network.
+type Caller interface {
+	Call(ctx context.Context, address string, rpcname string, args interface{}, reply interface{}) bool
 }
 
 func MakeClient(ip string, port string) *Client {
@@ -16,15 +25,74 @@ func MakeClient(ip string, port string) *Client {
 	return cl
 }
 
-func (cl *Client) Call(rpcname string, args interface{}, reply interface{}) bool {
-	address := string(cl.Ip) + ":" + cl.Port
-	c, err := rpc.DialHTTP("tcp", address)
+// MakeClientFromAddress makes a client from an "ip:port" address.
+func MakeClientFromAddress(address string) *Client {
+	ip, port, err := net.SplitHostPort(address)
 	if err != nil {
-		// log.Println(err)
-		return false
+		return MakeClient(address, "")
 	}
-	defer c.Close()
+	return MakeClient(ip, port)
+}
+
+// MakeClientVia makes a client whose RPCs are sent to address over caller.
+func MakeClientVia(caller Caller, address string) *Client {
+	cl := MakeClientFromAddress(address)
+	cl.caller = caller
+	return cl
+}
+
+// Dial makes a client for address over the same transport as cl.
+func (cl *Client) Dial(address string) *Client {
+	return MakeClientVia(cl.caller, address)
+}
 
-	err = c.Call(rpcname, args, reply)
-	return err == nil
+func (cl *Client) Address() string {
+	if cl.Port == "" {
+		return cl.Ip
+	}
+	return string(cl.Ip) + ":" + cl.Port
+}
+
+// Call sends the rpc over the pooled connection to the peer, reconnecting if it's broken.
+func (cl *Client) Call(rpcname string, args interface{}, reply interface{}) bool {
+	return cl.CallContext(context.Background(), rpcname, args, reply)
+}
+
+// CallContext is Call bounded by ctx: false is returned as soon as ctx is done.
+// warning: the reply may still be written after an abandoned call returns, so it must not be reused.
+func (cl *Client) CallContext(ctx context.Context, rpcname string, args interface{}, reply interface{}) bool {
+	address := cl.Address()
+	if cl.caller != nil {
+		return cl.caller.Call(ctx, address, rpcname, args, reply)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		c, err := connect(ctx, address)
+		if err != nil {
+			// log.Println(err)
+			return false
+		}
+
+		call, unsent := c.send(rpcname, args, reply)
+		if unsent {
+			// the call is only retried on a fresh connection if it never went out on the stale one.
+			disconnect(address, c)
+			continue
+		}
+		select {
+		case <-call.Done:
+			err = call.Error
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				// the peer may be gone without the connection knowing, so it's dropped
+				// rather than left with the abandoned call pending on it.
+				disconnect(address, c)
+			}
+			return false
+		}
+		if broken(err) {
+			disconnect(address, c)
+		}
+		return err == nil
+	}
+	return false
 }
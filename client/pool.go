@@ -0,0 +1,151 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 1 * time.Second
+
+// tlsConfig is set if the peers are to be reached over TLS.
+var tlsConfig *tls.Config
+
+// UseTLS makes all the clients of the process connect over TLS with config.
+func UseTLS(config *tls.Config) {
+	pool.Lock()
+	defer pool.Unlock()
+	tlsConfig = config
+}
+
+// pool keeps one connection per address, shared by all the clients of the process.
+// net/rpc multiplexes concurrent calls over a connection, so one is enough.
+var pool = struct {
+	sync.Mutex
+	conns map[string]*conn
+}{conns: make(map[string]*conn)}
+
+// conn is a pooled connection. it's only closed with mu locked, so that a call sent with
+// mu read-locked can tell whether it went out.
+type conn struct {
+	mu     sync.RWMutex
+	client *rpc.Client
+}
+
+// send sends the rpc over c. unsent is set if c was closed already, so that the call
+// provably never went out and may be retried elsewhere. note that net/rpc also fails
+// with rpc.ErrShutdown the calls that were sent before the connection was closed.
+func (c *conn) send(rpcname string, args interface{}, reply interface{}) (call *rpc.Call, unsent bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	call = c.client.Go(rpcname, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		// net/rpc fails a pending call with rpc.ErrShutdown only once the connection is
+		// closed, which can't happen while mu is read-locked: the call was never sent.
+		if call.Error == rpc.ErrShutdown {
+			return nil, true
+		}
+		call.Done <- call
+	default:
+	}
+	return call, false
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client.Close()
+}
+
+// connect returns the pooled connection to address, dialing it if there's none.
+func connect(ctx context.Context, address string) (*conn, error) {
+	pool.Lock()
+	c, ok := pool.conns[address]
+	pool.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	// note: the pool isn't locked while dialing, so that an unreachable peer
+	// doesn't block the calls to the others.
+	client, err := dialHTTP(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	c = &conn{client: client}
+
+	pool.Lock()
+	defer pool.Unlock()
+	if existing, ok := pool.conns[address]; ok {
+		// someone else has connected in the meantime.
+		client.Close()
+		return existing, nil
+	}
+	pool.conns[address] = c
+	return c, nil
+}
+
+// disconnect removes a broken connection from the pool, so that the next call reconnects.
+func disconnect(address string, c *conn) {
+	pool.Lock()
+	if pool.conns[address] == c {
+		delete(pool.conns, address)
+	}
+	pool.Unlock()
+	c.close()
+}
+
+// broken tells whether err means the connection can no longer be used.
+func broken(err error) bool {
+	var serverErr rpc.ServerError
+	return err != nil && !errors.As(err, &serverErr)
+}
+
+// dialHTTP is rpc.DialHTTP with a timeout, bounded by ctx as well.
+func dialHTTP(ctx context.Context, address string) (*rpc.Client, error) {
+	pool.Lock()
+	config := tlsConfig
+	pool.Unlock()
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if config == nil {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		host, _, _ := net.SplitHostPort(address)
+		// the peer's certificate must be valid for the IP it's reached at.
+		config = config.Clone()
+		config.ServerName = host
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: config}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// the handshake below must not outlive ctx either.
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+
+	// require successful HTTP response before switching to RPC protocol.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status == "200 Connected to Go RPC" {
+		return rpc.NewClient(conn), nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	conn.Close()
+	return nil, err
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy tells how a clerk paces the rounds of an op that no server got done yet,
+// e.g. during an election or an outage.
+type RetryPolicy struct {
+	InitialBackoff time.Duration // the wait after the first failed round.
+	MaxBackoff     time.Duration
+	Multiplier     float64 // the growth of the wait from one round to the next.
+	Jitter         float64 // the fraction of each wait that is randomized, in [0, 1].
+
+	MaxAttempts int           // the max number of rounds, 0 for no limit.
+	MaxDuration time.Duration // the max time spent on the op, 0 for no limit.
+}
+
+// DefaultRetryPolicy retries for as long as it takes, as the clerks always did.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     1 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+var ErrRetriesExhausted = errors.New("ErrRetriesExhausted")
+
+// Retrier paces the rounds of a single op.
+type Retrier struct {
+	policy   RetryPolicy
+	start    time.Time
+	attempts int
+	backoff  time.Duration
+}
+
+func NewRetrier(policy RetryPolicy) *Retrier {
+	return &Retrier{policy: policy, start: time.Now(), backoff: policy.InitialBackoff}
+}
+
+// Wait is called after a failed round, and waits before the next one.
+// it returns ErrRetriesExhausted once the budget is spent, or the error of ctx once it's done.
+func (r *Retrier) Wait(ctx context.Context) error {
+	r.attempts++
+	if r.policy.MaxAttempts > 0 && r.attempts >= r.policy.MaxAttempts {
+		return ErrRetriesExhausted
+	}
+	d := r.backoff
+	if r.policy.Jitter > 0 {
+		d += time.Duration(r.policy.Jitter * (2*rand.Float64() - 1) * float64(d))
+	}
+	if r.policy.MaxDuration > 0 && time.Since(r.start)+d >= r.policy.MaxDuration {
+		return ErrRetriesExhausted
+	}
+
+	r.backoff = time.Duration(float64(r.backoff) * r.policy.Multiplier)
+	if r.policy.MaxBackoff > 0 && r.backoff > r.policy.MaxBackoff {
+		r.backoff = r.policy.MaxBackoff
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Bound returns ctx, done by the end of the time budget of the op if it has one, for the
+// calls made on the way to the next round, e.g. to learn where the op should go.
+func (r *Retrier) Bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.policy.MaxDuration <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, r.start.Add(r.policy.MaxDuration))
+}
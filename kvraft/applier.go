@@ -1,5 +1,12 @@
 package kvraft
 
+import (
+	"strconv"
+	"time"
+
+	"DDB/auth"
+)
+
 func (kv *KVServer) applier() {
 	for m := range kv.applyCh {
 		if kv.Killed() {
@@ -7,16 +14,34 @@ func (kv *KVServer) applier() {
 		}
 		kv.mu.Lock()
 		if m.SnapshotValid {
-			// kv.ingestSnapshot(m.Snapshot)
+			if m.SnapshotIndex > kv.lastApplied {
+				kv.ingestSnapshot(m.Snapshot)
+			}
 
-		} else {
+		} else if m.CommandIndex > kv.lastApplied {
+			// note: the ops up to lastApplied are those of a snapshot installed meanwhile.
+			kv.lastApplied = m.CommandIndex
 			op := m.Command.(*Op)
+			result := Result{OpId: op.OpId, Err: OK}
 			if op.Type == "NoOp" {
 				// skip no-ops.
 
+			} else if op.Type == "Config" {
+				kv.applyConfig(op)
+
+			} else if op.Type == "InstallShard" {
+				kv.applyInstallShard(op)
+
+			} else if op.Type == "DeleteShard" {
+				kv.applyDeleteShard(op)
+
+			} else if op.Type == "ShardDeleted" {
+				kv.applyShardDeleted(op)
+
 			} else {
-				kv.apply(op)
+				result = kv.apply(op)
 			}
+			kv.deliver(m.CommandIndex, m.CommandTerm, result)
 
 			if kv.gc && kv.approachGCLimit() {
 				kv.checkpoint(m.CommandIndex)
@@ -27,49 +52,122 @@ func (kv *KVServer) applier() {
 }
 
 func (kv *KVServer) isApplied(op *Op) bool {
-	max, ok := kv.maxApplied[op.ClerkId]
-	return ok && max >= op.OpId
+	w, ok := kv.windows[op.ClerkId]
+	return ok && w.applied(op.OpId)
 }
 
-func (kv *KVServer) apply(op *Op) {
-	if kv.isApplied(op) {
-		return
+// keyless tells whether op is about no key in particular, and so is served by any group.
+func keyless(op *Op) bool {
+	return op.Type == "Scan" || isSessionOp(op.Type)
+}
+
+// apply applies a client op, and returns its result.
+func (kv *KVServer) apply(op *Op) Result {
+	kv.tick(op.Time)
+	var w *Window
+	if op.ClerkId != 0 {
+		if w = kv.session(op); w == nil {
+			return Result{OpId: op.OpId, Err: ErrSessionExpired}
+		}
+		if isSessionOp(op.Type) {
+			// note: session ops are idempotent, and take no op id of the clerk.
+			return kv.applySession(op)
+		}
+		w.ack(op.Acked)
+		if w.applied(op.OpId) {
+			if result, ok := w.Results[op.OpId]; ok {
+				return result
+			}
+			return Result{OpId: op.OpId, Err: OK}
+		}
+	}
+	if !keyless(op) && !kv.owns(op.Key) {
+		// the op is rejected rather than applied, so that it can be retried by the new owner.
+		return Result{OpId: op.OpId, Err: ErrWrongGroup}
 	}
+	kv.expire(op.Key, op.Time)
+	result := Result{OpId: op.OpId, Err: OK}
 	switch op.Type {
 	case "Get":
+		v, err := kv.lookup(op.Key, StringValue, false)
+		result.Err = err
+		if v != nil {
+			result.Values = []string{v.Str}
+		} else if err == OK {
+			result.Err = ErrNoKey
+		}
 
 	case "Put":
-		kv.db.Set(op.Key, op.Value)
+		v := &Value{Kind: StringValue, Str: op.Value}
+		if op.TTL > 0 {
+			v.Deadline = op.Time + op.TTL
+		}
+		kv.store(op.Key).Set(op.Key, v)
 
 	case "Append":
-		previous, _ := kv.db.Get(op.Key)
-		kv.db.Set(op.Key, previous+op.Value)
+		v, err := kv.lookup(op.Key, StringValue, true)
+		result.Err = err
+		if v != nil {
+			v.Str += op.Value
+			result.Values = []string{strconv.Itoa(len(v.Str))}
+		}
+
+	case "Delete", "Exists":
+		_, ok := kv.store(op.Key).Get(op.Key)
+		result.Values = []string{"0"}
+		if ok {
+			result.Values = []string{"1"}
+		}
+		if op.Type == "Delete" {
+			kv.store(op.Key).Delete(op.Key)
+		}
+
+	case "Incr":
+		result.Err, result.Values = kv.incr(op)
+
+	case "Expire":
+		result.Err, result.Values = kv.setExpiry(op)
+
+	case "Scan":
+		result.Values = kv.scan(op.Key, op.Limit, op.Time)
+
+	default:
+		if auth.IsOp(op.Type) {
+			result.Err = kv.applyAuth(op)
+		} else {
+			result.Err, result.Values = kv.applyTyped(op)
+		}
+	}
+	if w != nil {
+		w.record(op.OpId, result)
 	}
-	kv.maxApplied[op.ClerkId] = op.OpId
-	kv.notify(op)
+	if result.Err == OK && required(op.Type) == auth.Write && !auth.IsOp(op.Type) {
+		kv.publish(op)
+	}
+	return result
 }
 
-func (kv *KVServer) waitApply(op *Op) (Err, string) {
+func (kv *KVServer) waitApply(op *Op) (Err, []string) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	if !kv.isApplied(op) {
-		if !kv.start(op) {
-			return ErrWrongLeader, ""
+	if !isSessionOp(op.Type) && kv.isApplied(op) {
+		// a retry of an op applied already.
+		if result, ok := kv.windows[op.ClerkId].Results[op.OpId]; ok {
+			return result.Err, result.Values
 		}
-
-		// wait until applied or timeout.
-		kv.makeNotifier(op)
-		kv.wait(op)
+		return OK, nil
 	}
-
-	if kv.isApplied(op) {
-		value := ""
-		if op.Type == "Get" {
-			// note: the default value, i.e. an empty string, is returned if the key does not exist.
-			value, _ = kv.db.Get(op.Key)
-		}
-		return OK, value
+	if !keyless(op) && !kv.owns(op.Key) {
+		return ErrWrongGroup, nil
 	}
-	return ErrNotApplied, ""
+	op.Time = time.Now().UnixMilli()
+	index, term, isLeader := kv.rf.Start(op)
+	if !isLeader {
+		return ErrWrongLeader, nil
+	}
+	kv.load[kv.config.Shard(op.Key)]++
+
+	result := kv.wait(index, term)
+	return result.Err, result.Values
 }
@@ -0,0 +1,80 @@
+package kvraft
+
+//
+// the async API of the clerk, which keeps many ops in flight at once so that a single
+// clerk isn't limited to one op per round trip:
+//   futures := []*kvraft.Future{}
+//   for _, key := range keys {
+//     futures = append(futures, ck.PutAsync(ctx, key, value))
+//   }
+//   for _, f := range futures {
+//     if _, err := f.Wait(); err != nil { ... }
+//   }
+// ops in flight together may be applied in any order. the servers still apply each op once,
+// whatever order its retries arrive in.
+//
+
+import "context"
+
+// maxInflight bounds the async ops of a clerk in flight at once, and so the ops
+// the servers must keep the results of.
+const maxInflight = 64
+
+// Future is the outcome of an async op, available once Done is closed.
+type Future struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits until the op is done, and returns the value it got, if any, along with its error.
+func (f *Future) Wait() (string, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// async runs op in the background once a slot is free, or fails once ctx is done.
+func (ck *Clerk) async(ctx context.Context, op func() (string, error)) *Future {
+	f := &Future{done: make(chan struct{})}
+	select {
+	case ck.slots <- struct{}{}:
+	case <-ctx.Done():
+		f.err = ctx.Err()
+		close(f.done)
+		return f
+	}
+	go func() {
+		defer close(f.done)
+		defer func() { <-ck.slots }()
+		f.value, f.err = op()
+	}()
+	return f
+}
+
+func (ck *Clerk) GetAsync(ctx context.Context, key string) *Future {
+	return ck.async(ctx, func() (string, error) {
+		return ck.GetContext(ctx, key)
+	})
+}
+
+func (ck *Clerk) PutAsync(ctx context.Context, key string, value string) *Future {
+	return ck.async(ctx, func() (string, error) {
+		return "", ck.PutContext(ctx, key, value)
+	})
+}
+
+func (ck *Clerk) AppendAsync(ctx context.Context, key string, value string) *Future {
+	return ck.async(ctx, func() (string, error) {
+		return "", ck.AppendContext(ctx, key, value)
+	})
+}
+
+func (ck *Clerk) DeleteAsync(ctx context.Context, key string) *Future {
+	return ck.async(ctx, func() (string, error) {
+		return "", ck.DeleteContext(ctx, key)
+	})
+}
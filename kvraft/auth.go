@@ -0,0 +1,50 @@
+package kvraft
+
+import "DDB/auth"
+
+//
+// users, roles and permissions, see the auth package. an unsharded server keeps its own
+// auth.Store, changed by the auth ops through raft, while the groups of a sharded deployment
+// take the store of each config they apply, the shard controller being in charge of the users.
+// the auth ops are then sent to the controller, and a group rejects them.
+//
+
+// the permission required by each op, apart from the auth ops.
+func required(op string) auth.Permission {
+	switch op {
+	case "Get", "HGet", "LRange", "SMembers", "Scan", "Exists":
+		return auth.Read
+	}
+	return auth.Write
+}
+
+// authorize checks that the holder of token has the permission perm on key.
+func (kv *KVServer) authorize(token string, key string, perm auth.Permission) Err {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.gid != 0 && kv.config.Num == 0 {
+		// fail closed: the group has yet to learn the users from a config.
+		return ErrWrongGroup
+	}
+	return authErr(kv.auth.Authorize(token, key, perm))
+}
+
+// authErr maps an error of the auth package to the Err of a reply.
+func authErr(e error) Err {
+	switch e {
+	case nil:
+		return OK
+	case auth.ErrUnauthenticated:
+		return ErrUnauthenticated
+	case auth.ErrPermissionDenied:
+		return ErrPermissionDenied
+	case auth.ErrNoUser:
+		return ErrNoKey
+	}
+	return ErrUnknownOp
+}
+
+// applyAuth applies an auth op to the users of an unsharded server.
+func (kv *KVServer) applyAuth(op *Op) Err {
+	return authErr(kv.auth.Apply(op.Type, op.Key, op.Field, op.Value))
+}
@@ -1,18 +1,41 @@
 package kvraft
 
 import (
+	"context"
 	"crypto/rand"
 	"math/big"
+	"sort"
+	"sync"
+	"time"
 
 	"DDB/client"
+	"DDB/shardctrler"
 )
 
+// callTimeout bounds a single RPC to a server. it's longer than maxWaitTime,
+// for which the server may wait for the op to be applied.
+const callTimeout = 1 * time.Second
+
 type Clerk struct {
+	mu      sync.Mutex
 	servers []*client.Client
 	// You will have to modify this struct.
-	id     int64
-	leader int
-	opId   int
+	id       int64
+	leaders  map[int]int // gid -> index of the last known leader in its servers.
+	terms    map[int]int // gid -> the latest term heard of, to tell stale leader hints.
+	opId     int
+	inflight map[int]bool      // the ids of the ops in flight.
+	sessions map[int]time.Time // gid -> when the session with the group was last known to be alive.
+	slots    chan struct{}     // a slot is taken by each async op in flight.
+	token    string            // "user:secret", set by Login.
+	policy   RetryPolicy
+
+	// set if the clerk talks to a sharded deployment.
+	ctrler    *shardctrler.Clerk
+	refreshMu sync.Mutex // the controller clerk serves one query at a time.
+	config    shardctrler.Config
+	groups    map[int][]*client.Client
+	origin    *client.Client // the groups are dialed over the same transport as this controller.
 }
 
 func nrand() int64 {
@@ -27,57 +50,313 @@ func MakeClerk(servers []*client.Client) *Clerk {
 	ck.servers = servers
 	// You'll have to add code here.
 	ck.id = nrand()
-	ck.leader = 0
+	ck.leaders = make(map[int]int)
+	ck.terms = make(map[int]int)
 	ck.opId = 0
+	ck.inflight = make(map[int]bool)
+	ck.sessions = make(map[int]time.Time)
+	ck.slots = make(chan struct{}, maxInflight)
+	ck.policy = DefaultRetryPolicy
+	return ck
+}
+
+// MakeShardClerk makes a clerk that routes every key to the group in charge of it,
+// as told by the shard controller.
+func MakeShardClerk(ctrlers []*client.Client) *Clerk {
+	ck := MakeClerk(nil)
+	ck.ctrler = shardctrler.MakeClerk(ctrlers)
+	ck.origin = ctrlers[0]
+	ck.refresh(context.Background())
 	return ck
 }
 
-func (ck *Clerk) allocateOpId() int {
+// route returns the group in charge of key and its servers.
+func (ck *Clerk) route(key string) (int, []*client.Client) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	if ck.ctrler == nil {
+		return 0, ck.servers
+	}
+	gid := ck.config.Owner(ck.config.Shard(key))
+	return gid, ck.groups[gid]
+}
+
+// refresh fetches the latest config from the shard controller.
+func (ck *Clerk) refresh(ctx context.Context) {
+	if ck.ctrler == nil {
+		return
+	}
+	ck.refreshMu.Lock()
+	defer ck.refreshMu.Unlock()
+	config, err := ck.ctrler.QueryContext(ctx, -1)
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	if err != nil || config.Num <= ck.config.Num {
+		return
+	}
+	ck.config = config
+	ck.groups = make(map[int][]*client.Client)
+	for gid, servers := range config.Groups {
+		for _, server := range servers {
+			ck.groups[gid] = append(ck.groups[gid], ck.origin.Dial(server))
+		}
+	}
+}
+
+// Login makes the clerk authenticate as user in every later request.
+func (ck *Clerk) Login(user string, secret string) {
+	ck.token = user + ":" + secret
+}
+
+// allocateOpId returns the id of a new op, which is in flight until done is called with it,
+// along with the ack to send with it: the id of the oldest op in flight.
+func (ck *Clerk) allocateOpId() (int, int) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
 	opId := ck.opId
 	ck.opId++
-	return opId
+	ck.inflight[opId] = true
+	acked := opId
+	for id := range ck.inflight {
+		if id < acked {
+			acked = id
+		}
+	}
+	return opId, acked
 }
 
-func (ck *Clerk) Get(key string) string {
-	args := GetArgs{}
-	args.Key = key
-	args.OpId = ck.allocateOpId()
-	args.ClerkId = ck.id
+func (ck *Clerk) done(opId int) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	delete(ck.inflight, opId)
+}
+
+// request is implemented by the args of the RPCs sent to the group in charge of a key.
+type request interface {
+	key() string
+	route(gid int)
+}
+
+func (args *GetArgs) key() string         { return args.Key }
+func (args *GetArgs) route(gid int)       { args.Gid = gid }
+func (args *PutAppendArgs) key() string   { return args.Key }
+func (args *PutAppendArgs) route(gid int) { args.Gid = gid }
+func (args *CommandArgs) key() string     { return args.Key }
+func (args *CommandArgs) route(gid int)   { args.Gid = gid }
+
+// hinted is implemented by the replies that tell where the leader is.
+type hinted interface {
+	hint() Hint
+}
+
+func (reply *GetReply) hint() Hint       { return reply.Hint }
+func (reply *PutAppendReply) hint() Hint { return reply.Hint }
+func (reply *CommandReply) hint() Hint   { return reply.Hint }
+
+// follow returns the index in servers of the leader told by hint,
+// or -1 if the hint is stale or the leader unknown. the caller holds ck.mu.
+func (ck *Clerk) follow(gid int, servers []*client.Client, hint Hint) int {
+	if hint.Leader == "" || hint.Term < ck.terms[gid] {
+		return -1
+	}
+	ck.terms[gid] = hint.Term
+	for i, server := range servers {
+		if server.Address() == hint.Leader {
+			return i
+		}
+	}
+	return -1
+}
+
+// call sends the rpc to the group in charge of the key of args until one of its servers
+// gets it done, ctx is done or the retry policy gives up. reply must make a new reply and
+// return the Err it carries. the reply is returned along with an error if the op failed.
+func (ck *Clerk) call(
+	ctx context.Context,
+	rpcname string,
+	args request,
+	reply func() (interface{}, *Err),
+) (interface{}, error) {
+	retry := ck.retrier()
 	for {
-		for i := range ck.servers {
-			serverId := (ck.leader + i) % len(ck.servers)
-			reply := GetReply{}
-			ok := ck.servers[serverId].Call("KVServer.Get", &args, &reply)
-			if ok {
-				if reply.Err == OK {
-					ck.leader = serverId
-					return reply.Value
+		gid, servers := ck.route(args.key())
+		if r, err, done := ck.callGroup(ctx, gid, servers, rpcname, args, reply); done {
+			return r, err
+		}
+		if err := retry.wait(ctx); err != nil {
+			return nil, err
+		}
+		retry.refresh(ctx, ck)
+	}
+}
+
+// callGroup tries as many servers of group gid as it has, starting from the last known leader
+// and jumping to the leader told by a server that isn't. done is false if none of them got
+// the op done and it should be retried, possibly on another group.
+func (ck *Clerk) callGroup(
+	ctx context.Context,
+	gid int,
+	servers []*client.Client,
+	rpcname string,
+	args request,
+	reply func() (interface{}, *Err),
+) (interface{}, error, bool) {
+	args.route(gid)
+	if len(servers) == 0 {
+		return nil, nil, false
+	}
+	if c, ok := args.(*CommandArgs); !ok || !isSessionOp(c.Op) {
+		if err, done := ck.openSession(ctx, gid, servers); !done || err != nil {
+			return nil, err, done
+		}
+	}
+	ck.mu.Lock()
+	serverId := ck.leaders[gid] % len(servers)
+	ck.mu.Unlock()
+	// the servers that failed the op in this round. a hint never leads back to one of them,
+	// lest a stale leader cut off from its group and a follower on its side bounce the op
+	// between them for the whole round.
+	failed := make(map[int]bool)
+	for tries := 0; tries < len(servers); tries++ {
+		r, err := reply()
+		callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+		ok := servers[serverId].CallContext(callCtx, rpcname, args, r)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err(), true
+		}
+		next := (serverId + 1) % len(servers)
+		failed[serverId] = true
+		if !ok {
+			serverId = next
+			continue
+		}
+		if *err == OK {
+			ck.setLeader(gid, serverId)
+			return r, nil, true
+		}
+		if *err == ErrWrongType || *err == ErrUnknownOp || *err == ErrNoKey || *err == ErrNotInteger {
+			// note: the op has been applied even if it failed, hence there's no need to retry.
+			ck.setLeader(gid, serverId)
+			return r, *err, true
+		}
+		if *err == ErrSessionExpired {
+			// the op may have been applied before the session expired, so it can't be retried.
+			// the next op opens a new session.
+			ck.mu.Lock()
+			delete(ck.sessions, gid)
+			ck.mu.Unlock()
+			return r, *err, true
+		}
+		if *err == ErrUnauthenticated || *err == ErrPermissionDenied {
+			// retrying can't help, the op is refused by every server alike.
+			return r, *err, true
+		}
+		if *err == ErrWrongGroup {
+			break
+		}
+		if *err == ErrWrongLeader {
+			if h, ok := r.(hinted); ok {
+				ck.mu.Lock()
+				if leader := ck.follow(gid, servers, h.hint()); leader >= 0 && !failed[leader] {
+					next = leader
 				}
+				ck.mu.Unlock()
 			}
 		}
+		serverId = next
+	}
+	return nil, nil, false
+}
+
+// setLeader records the server that got an op done, which also shows the session
+// of the clerk with the group is alive.
+func (ck *Clerk) setLeader(gid int, serverId int) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	ck.leaders[gid] = serverId
+	if _, ok := ck.sessions[gid]; ok {
+		ck.sessions[gid] = time.Now()
+	}
+}
+
+// openSession registers the session of the clerk with group gid, or sends a keep-alive
+// if the session has been idle for long. as with callGroup, done is false if the group
+// couldn't be reached, and the error is set if the session was refused, e.g. to an unknown user.
+func (ck *Clerk) openSession(ctx context.Context, gid int, servers []*client.Client) (error, bool) {
+	ck.mu.Lock()
+	seen, ok := ck.sessions[gid]
+	ck.mu.Unlock()
+	if ok && time.Since(seen) < sessionTimeout/2 {
+		return nil, true
+	}
+	args := &CommandArgs{Op: "RegisterClient", ClerkId: ck.id, Token: ck.token}
+	if ok {
+		args.Op = "KeepAlive"
+	}
+	_, err, done := ck.callGroup(ctx, gid, servers, "KVServer.Command", args, func() (interface{}, *Err) {
+		reply := &CommandReply{}
+		return reply, &reply.Err
+	})
+	if err == Err(ErrSessionExpired) {
+		// the clerk was idle for longer than its session lived: register anew.
+		return ck.openSession(ctx, gid, servers)
+	}
+	if !done || err != nil {
+		return err, done
+	}
+	ck.mu.Lock()
+	ck.sessions[gid] = time.Now()
+	ck.mu.Unlock()
+	return nil, true
+}
+
+// Get fetches the current value of key, or "" if there's none. it returns "" as well if
+// the op fails, e.g. once a bounded retry policy gives up on it, which only GetContext tells.
+func (ck *Clerk) Get(key string) string {
+	value, _ := ck.GetContext(context.Background(), key)
+	return value
+}
+
+// GetContext fetches the current value of key, or returns an error once ctx is done.
+func (ck *Clerk) GetContext(ctx context.Context, key string) (string, error) {
+	args := GetArgs{}
+	args.Key = key
+	args.OpId, args.Acked = ck.allocateOpId()
+	defer ck.done(args.OpId)
+	args.ClerkId = ck.id
+	args.Token = ck.token
+	r, err := ck.call(ctx, "KVServer.Get", &args, func() (interface{}, *Err) {
+		reply := &GetReply{}
+		return reply, &reply.Err
+	})
+	if r == nil {
+		return "", err
 	}
+	return r.(*GetReply).Value, err
 }
 
+// PutAppend puts, appends to or deletes key. a failure, e.g. once a bounded retry policy
+// gives up on the op, goes unreported, and the op may or may not have been applied; the
+// Context variants return the error, ErrRetriesExhausted in that case.
 func (ck *Clerk) PutAppend(key string, value string, op string) {
+	ck.PutAppendContext(context.Background(), key, value, op)
+}
+
+func (ck *Clerk) PutAppendContext(ctx context.Context, key string, value string, op string) error {
 	args := PutAppendArgs{}
 	args.Key = key
-	args.OpId = ck.allocateOpId()
+	args.OpId, args.Acked = ck.allocateOpId()
+	defer ck.done(args.OpId)
 	args.Op = op
 	args.Value = value
 	args.ClerkId = ck.id
-	for {
-		for i := range ck.servers {
-			serverId := (ck.leader + i) % len(ck.servers)
-			reply := PutAppendReply{}
-			ok := ck.servers[serverId].Call("KVServer.PutAppend", &args, &reply)
-			if ok {
-				if reply.Err == OK {
-					ck.leader = serverId
-					return
-				}
-			}
-		}
-	}
+	args.Token = ck.token
+	_, err := ck.call(ctx, "KVServer.PutAppend", &args, func() (interface{}, *Err) {
+		reply := &PutAppendReply{}
+		return reply, &reply.Err
+	})
+	return err
 }
 
 func (ck *Clerk) Put(key string, value string) {
@@ -86,3 +365,208 @@ func (ck *Clerk) Put(key string, value string) {
 func (ck *Clerk) Append(key string, value string) {
 	ck.PutAppend(key, value, "Append")
 }
+
+func (ck *Clerk) PutContext(ctx context.Context, key string, value string) error {
+	return ck.PutAppendContext(ctx, key, value, "Put")
+}
+
+func (ck *Clerk) AppendContext(ctx context.Context, key string, value string) error {
+	return ck.PutAppendContext(ctx, key, value, "Append")
+}
+
+// Command runs a command op, and returns nil if it fails, see CommandContext for the error.
+func (ck *Clerk) Command(args *CommandArgs) []string {
+	values, _ := ck.CommandContext(context.Background(), args)
+	return values
+}
+
+func (ck *Clerk) CommandContext(ctx context.Context, args *CommandArgs) ([]string, error) {
+	args.OpId, args.Acked = ck.allocateOpId()
+	defer ck.done(args.OpId)
+	args.ClerkId = ck.id
+	args.Token = ck.token
+	r, err := ck.call(ctx, "KVServer.Command", args, func() (interface{}, *Err) {
+		reply := &CommandReply{}
+		return reply, &reply.Err
+	})
+	if r == nil {
+		return nil, err
+	}
+	return r.(*CommandReply).Values, err
+}
+
+func (ck *Clerk) Delete(key string) {
+	ck.PutAppend(key, "", "Delete")
+}
+
+func (ck *Clerk) DeleteContext(ctx context.Context, key string) error {
+	return ck.PutAppendContext(ctx, key, "", "Delete")
+}
+
+func (ck *Clerk) Scan(prefix string, limit int) []KeyValue {
+	pairs, _ := ck.ScanContext(context.Background(), prefix, limit)
+	return pairs
+}
+
+// ScanContext returns the string values of up to limit keys starting with prefix, in key order.
+// a limit of 0 means no limit. in a sharded deployment, every group is scanned, and the scan
+// is only consistent within each group.
+func (ck *Clerk) ScanContext(ctx context.Context, prefix string, limit int) ([]KeyValue, error) {
+	args := &CommandArgs{Op: "Scan", Key: prefix, Limit: limit}
+	if ck.ctrler == nil {
+		values, err := ck.CommandContext(ctx, args)
+		return pairs(values, limit), err
+	}
+	args.OpId, args.Acked = ck.allocateOpId()
+	defer ck.done(args.OpId)
+	args.ClerkId = ck.id
+	args.Token = ck.token
+	replies, err := ck.broadcast(ctx, "KVServer.Command", args, func() (interface{}, *Err) {
+		reply := &CommandReply{}
+		return reply, &reply.Err
+	})
+	values := []string{}
+	for _, r := range replies {
+		values = append(values, r.(*CommandReply).Values...)
+	}
+	return pairs(values, limit), err
+}
+
+// pairs makes up to limit key-value pairs, sorted by key, out of a flat list of keys and values.
+func pairs(values []string, limit int) []KeyValue {
+	kvs := []KeyValue{}
+	for i := 0; i+1 < len(values); i += 2 {
+		kvs = append(kvs, KeyValue{values[i], values[i+1]})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs
+}
+
+func (ck *Clerk) HSet(key string, field string, value string) {
+	ck.Command(&CommandArgs{Op: "HSet", Key: key, Field: field, Value: value})
+}
+
+func (ck *Clerk) HGet(key string, field string) string {
+	values := ck.Command(&CommandArgs{Op: "HGet", Key: key, Field: field})
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (ck *Clerk) HDel(key string, field string) {
+	ck.Command(&CommandArgs{Op: "HDel", Key: key, Field: field})
+}
+
+func (ck *Clerk) LPush(key string, values ...string) {
+	ck.Command(&CommandArgs{Op: "LPush", Key: key, Values: values})
+}
+
+func (ck *Clerk) RPop(key string) string {
+	values := ck.Command(&CommandArgs{Op: "RPop", Key: key})
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (ck *Clerk) LRange(key string, start int, stop int) []string {
+	return ck.Command(&CommandArgs{Op: "LRange", Key: key, Start: start, Stop: stop})
+}
+
+func (ck *Clerk) SAdd(key string, members ...string) {
+	ck.Command(&CommandArgs{Op: "SAdd", Key: key, Values: members})
+}
+
+func (ck *Clerk) SRem(key string, members ...string) {
+	ck.Command(&CommandArgs{Op: "SRem", Key: key, Values: members})
+}
+
+func (ck *Clerk) SMembers(key string) []string {
+	return ck.Command(&CommandArgs{Op: "SMembers", Key: key})
+}
+
+// broadcast sends the rpc to every group of the current config until each of them got it done,
+// and returns their replies. the retry policy applies to each group on its own.
+func (ck *Clerk) broadcast(
+	ctx context.Context,
+	rpcname string,
+	args request,
+	reply func() (interface{}, *Err),
+) ([]interface{}, error) {
+	ck.mu.Lock()
+	gids := make([]int, 0, len(ck.config.Groups))
+	for gid := range ck.config.Groups {
+		gids = append(gids, gid)
+	}
+	ck.mu.Unlock()
+	sort.Ints(gids)
+	replies := []interface{}{}
+	for _, gid := range gids {
+		retry := ck.retrier()
+		for {
+			ck.mu.Lock()
+			servers, ok := ck.groups[gid]
+			ck.mu.Unlock()
+			if !ok {
+				// the group has left meanwhile.
+				break
+			}
+			r, err, done := ck.callGroup(ctx, gid, servers, rpcname, args, reply)
+			if done && err != nil {
+				return nil, err
+			}
+			if done {
+				replies = append(replies, r)
+				break
+			}
+			if err := retry.wait(ctx); err != nil {
+				return nil, err
+			}
+			retry.refresh(ctx, ck)
+		}
+	}
+	return replies, nil
+}
+
+// admin applies an auth op. in a sharded deployment, the users are kept by the shard controller.
+func (ck *Clerk) admin(args *CommandArgs) error {
+	if ck.ctrler == nil {
+		_, err := ck.CommandContext(context.Background(), args)
+		return err
+	}
+	err := ck.ctrler.Auth(ck.token, args.Op, args.Key, args.Field, args.Value)
+	if e, ok := err.(shardctrler.Err); ok {
+		return Err(e)
+	}
+	if err == client.ErrRetriesExhausted {
+		return Err(ErrRetriesExhausted)
+	}
+	return err
+}
+
+// AddUser adds a user, or changes the secret of an existing one.
+func (ck *Clerk) AddUser(user string, secret string) error {
+	return ck.admin(&CommandArgs{Op: "AddUser", Key: user, Value: secret})
+}
+
+func (ck *Clerk) DeleteUser(user string) error {
+	return ck.admin(&CommandArgs{Op: "DeleteUser", Key: user})
+}
+
+func (ck *Clerk) GrantRole(user string, role string) error {
+	return ck.admin(&CommandArgs{Op: "GrantRole", Key: user, Field: role})
+}
+
+func (ck *Clerk) RevokeRole(user string, role string) error {
+	return ck.admin(&CommandArgs{Op: "RevokeRole", Key: user, Field: role})
+}
+
+// SetPermission grants role the permission "read", "write" or "admin" on every key
+// starting with prefix. an empty permission revokes it.
+func (ck *Clerk) SetPermission(role string, prefix string, permission string) error {
+	return ck.admin(&CommandArgs{Op: "SetPermission", Key: role, Field: prefix, Value: permission})
+}
@@ -1,28 +1,50 @@
 package kvraft
 
+import btree "DDB/map"
+
 const (
 	OK             = "OK"
 	ErrNoKey       = "ErrNoKey"
 	ErrWrongLeader = "ErrWrongLeader"
+	ErrWrongGroup  = "ErrWrongGroup"
+	ErrNotReady    = "ErrNotReady"
 	ErrNotApplied  = "ErrNotApplied"
+	ErrWrongType   = "ErrWrongType"
+	ErrUnknownOp   = "ErrUnknownOp"
+	ErrNotInteger  = "ErrNotInteger"
+
+	ErrUnauthenticated  = "ErrUnauthenticated"
+	ErrPermissionDenied = "ErrPermissionDenied"
+	ErrSessionExpired   = "ErrSessionExpired"
+
+	// returned by the clerk, once its retry policy gave up on an op.
+	ErrRetriesExhausted = "ErrRetriesExhausted"
 )
 
 type Err string
 
+func (e Err) Error() string {
+	return string(e)
+}
+
 // Put or Append
 type PutAppendArgs struct {
 	Key   string
 	Value string
-	Op    string // "Put" or "Append"
+	Op    string // "Put", "Append" or "Delete"
 	// You'll have to add definitions here.
 	// Field names must start with capital letters,
 	// otherwise RPC will break.
 	OpId    int
 	ClerkId int64
+	Acked   int    // every op of the clerk below Acked is done with.
+	Gid     int    // the group the args are sent to, for multi-raft hosts.
+	Token   string // "user:secret", if authentication is on.
 }
 
 type PutAppendReply struct {
-	Err Err
+	Err  Err
+	Hint Hint
 }
 
 type GetArgs struct {
@@ -30,9 +52,73 @@ type GetArgs struct {
 	// You'll have to add definitions here.
 	OpId    int
 	ClerkId int64
+	Acked   int
+	Gid     int
+	Token   string
 }
 
 type GetReply struct {
 	Err   Err
 	Value string
+	Hint  Hint
+}
+
+// hash, list and set operations, and scans.
+type CommandArgs struct {
+	Op     string // "HSet", "HGet", "HDel", "LPush", "RPop", "LRange", "SAdd", "SRem", "SMembers", "Scan", "Exists", "Incr", "Expire", any of the key operations, an auth op, "RegisterClient" or "KeepAlive"
+	Key    string // the prefix for "Scan".
+	Field  string
+	Value  string
+	Values []string
+	Start  int
+	Stop   int
+	Limit  int   // the max number of keys for "Scan", 0 for no limit.
+	TTL    int64 // the time to live in ms of the value of a "Put", 0 for ever.
+
+	OpId    int
+	ClerkId int64
+	Acked   int
+	Gid     int
+	Token   string
+}
+
+type CommandReply struct {
+	Err    Err
+	Values []string
+	Hint   Hint
+}
+
+// Hint tells where the leader is, along with an ErrWrongLeader.
+type Hint struct {
+	LeaderId int // the index of the leader in the peers of the replying server, -1 if unknown.
+	Term     int
+	Leader   string // the address of the leader, empty if unknown.
+}
+
+// shard migration between replica groups.
+type PullShardArgs struct {
+	ConfigNum int
+	Shards    []int
+	Gid       int
+}
+
+type PullShardReply struct {
+	Err     Err
+	Shards  map[int]*btree.Map[string, *Value]
+	Windows map[int64]*Window
+}
+
+type DeleteShardArgs struct {
+	ConfigNum int
+	Shards    []int
+	Gid       int
+}
+
+type DeleteShardReply struct {
+	Err Err
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
 }
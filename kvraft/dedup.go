@@ -0,0 +1,97 @@
+package kvraft
+
+// Window tracks which ops of a clerk have been applied, so that each is applied once
+// even when a clerk keeps many ops in flight and they arrive out of order.
+// the clerk acks the ops it's done with, which lets the window slide past them.
+type Window struct {
+	Base    int            // every op below Base has been applied, or given up on by the clerk.
+	Applied map[int]bool   // the ops at or above Base that have been applied.
+	Results map[int]Result // the results of the applied ops that aren't acked yet.
+
+	Deadline int64 // the log time in ms at which the session of the clerk expires, unless it shows up again.
+}
+
+func makeWindow() *Window {
+	return &Window{Applied: make(map[int]bool), Results: make(map[int]Result)}
+}
+
+func (w *Window) applied(opId int) bool {
+	return opId < w.Base || w.Applied[opId]
+}
+
+func (w *Window) record(opId int, result Result) {
+	if opId >= w.Base {
+		w.Applied[opId] = true
+	}
+	w.Results[opId] = result
+	w.slide()
+}
+
+// ack forgets the ops below acked, which the clerk won't ask about anymore.
+func (w *Window) ack(acked int) {
+	for opId := range w.Results {
+		if opId < acked {
+			delete(w.Results, opId)
+		}
+	}
+	w.advance(acked)
+}
+
+// advance moves Base up to base, if it's below.
+func (w *Window) advance(base int) {
+	if base > w.Base {
+		for opId := range w.Applied {
+			if opId < base {
+				delete(w.Applied, opId)
+			}
+		}
+		w.Base = base
+	}
+	w.slide()
+}
+
+func (w *Window) slide() {
+	for w.Applied[w.Base] {
+		delete(w.Applied, w.Base)
+		w.Base++
+	}
+}
+
+// merge adds the ops applied in other, e.g. by the previous owner of a shard.
+func (w *Window) merge(other *Window) {
+	if other.Deadline > w.Deadline {
+		w.Deadline = other.Deadline
+	}
+	for opId := range other.Applied {
+		if opId >= w.Base {
+			w.Applied[opId] = true
+		}
+	}
+	for opId, result := range other.Results {
+		if _, ok := w.Results[opId]; !ok {
+			w.Results[opId] = result
+		}
+	}
+	w.advance(other.Base)
+}
+
+func (w *Window) copy() *Window {
+	c := &Window{Base: w.Base, Applied: make(map[int]bool), Results: make(map[int]Result), Deadline: w.Deadline}
+	for opId := range w.Applied {
+		c.Applied[opId] = true
+	}
+	for opId, result := range w.Results {
+		c.Results[opId] = result
+	}
+	return c
+}
+
+// window returns the window of clerkId, making it if needed.
+func (kv *KVServer) window(clerkId int64) *Window {
+	w, ok := kv.windows[clerkId]
+	if !ok {
+		w = makeWindow()
+		kv.windows[clerkId] = w
+	}
+	return w
+}
@@ -0,0 +1,60 @@
+package kvraft
+
+import "strconv"
+
+//
+// keys expire lazily: an expired key is deleted when an op next touches it.
+// the ops carry the time at which they were started, so that every replica
+// agrees on whether a key has expired when applying an op.
+//
+
+func (v *Value) expired(now int64) bool {
+	return v.Deadline != 0 && v.Deadline <= now
+}
+
+// expire deletes key if it has expired at now.
+func (kv *KVServer) expire(key string, now int64) {
+	if v, ok := kv.store(key).Get(key); ok && v.expired(now) {
+		kv.store(key).Delete(key)
+	}
+}
+
+// setExpiry makes the key of op expire op.Value ms after the op, or right away if that's not
+// positive. it returns "1" if the key exists, "0" otherwise.
+func (kv *KVServer) setExpiry(op *Op) (Err, []string) {
+	ttl, err := strconv.ParseInt(op.Value, 10, 64)
+	if err != nil {
+		return ErrNotInteger, nil
+	}
+	v, ok := kv.store(op.Key).Get(op.Key)
+	if !ok {
+		return OK, []string{"0"}
+	}
+	if ttl <= 0 {
+		kv.store(op.Key).Delete(op.Key)
+	} else {
+		v.Deadline = op.Time + ttl
+	}
+	return OK, []string{"1"}
+}
+
+// incr adds op.Value to the integer stored under the key of op, a missing key counting as 0,
+// and returns the sum.
+func (kv *KVServer) incr(op *Op) (Err, []string) {
+	delta, err := strconv.ParseInt(op.Value, 10, 64)
+	if err != nil {
+		return ErrNotInteger, nil
+	}
+	v, e := kv.lookup(op.Key, StringValue, true)
+	if e != OK {
+		return e, nil
+	}
+	n := int64(0)
+	if v.Str != "" {
+		if n, err = strconv.ParseInt(v.Str, 10, 64); err != nil {
+			return ErrNotInteger, nil
+		}
+	}
+	v.Str = strconv.FormatInt(n+delta, 10)
+	return OK, []string{v.Str}
+}
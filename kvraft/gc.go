@@ -1,33 +1,76 @@
 package kvraft
 
 import (
-	"DDB/labgob"
 	"bytes"
+
+	"DDB/auth"
+	"DDB/labgob"
+	"DDB/shardctrler"
+
+	btree "DDB/map"
 )
 
-// a snapshotting starts if the raft state size is higher than GCRatio * maxRaftStateSize.
+// a snapshotting starts if the raft state size is higher than GCRatio * MaxRaftState of the raft config.
 const GCRatio = 0.8
 
 func (kv *KVServer) approachGCLimit() bool {
 	// note: persister has its own mutex and hence no race would be raised with raft.
-	return true
-	// return float32(kv.persister.RaftStateSize()) > GCRatio*float32(kv.maxraftstate)
+	return float32(kv.persister.RaftStateSize()) > GCRatio*float32(kv.rf.Config().MaxRaftState)
 }
 
+// ingestSnapshot replaces the state of the server by that of snapshot, which holds the
+// shards with their typed values, the windows and sessions of the clerks, and the configs.
 func (kv *KVServer) ingestSnapshot(snapshot []byte) {
 	r := bytes.NewBuffer(snapshot)
 	d := labgob.NewDecoder(r)
-	if d.Decode(&kv.db) != nil || d.Decode(&kv.maxApplied) != nil {
+	// note: decoded into fresh variables, since gob merges into the existing maps.
+	var db map[int]*btree.Map[string, *Value]
+	var windows map[int64]*Window
+	var config, prevConfig shardctrler.Config
+	var states map[int]ShardState
+	var users *auth.Store
+	var now, nextSweep int64
+	var lastApplied int
+	if d.Decode(&db) != nil || d.Decode(&windows) != nil ||
+		d.Decode(&config) != nil || d.Decode(&prevConfig) != nil || d.Decode(&states) != nil ||
+		d.Decode(&users) != nil || d.Decode(&now) != nil || d.Decode(&nextSweep) != nil ||
+		d.Decode(&lastApplied) != nil {
 		panic("failed to decode some fields")
 	}
+	kv.db, kv.windows, kv.config, kv.prevConfig, kv.states = db, windows, config, prevConfig, states
+	kv.auth, kv.now, kv.nextSweep, kv.lastApplied = users, now, nextSweep, lastApplied
+	// note: gob leaves empty maps as nil.
+	if kv.db == nil {
+		kv.db = make(map[int]*btree.Map[string, *Value])
+	}
+	if kv.windows == nil {
+		kv.windows = make(map[int64]*Window)
+	}
+	if kv.states == nil {
+		kv.states = make(map[int]ShardState)
+	}
+	if kv.auth == nil {
+		kv.auth = auth.MakeStore()
+	}
+	for _, w := range kv.windows {
+		if w.Applied == nil {
+			w.Applied = make(map[int]bool)
+		}
+		if w.Results == nil {
+			w.Results = make(map[int]Result)
+		}
+	}
 }
 
 func (kv *KVServer) makeSnapshot() []byte {
 	w := new(bytes.Buffer)
-	// e := labgob.NewEncoder(w)
-	// if e.Encode(kv.db) != nil || e.Encode(kv.maxApplied) != nil {
-	// 	panic("failed to encode some fields")
-	// }
+	e := labgob.NewEncoder(w)
+	if e.Encode(kv.db) != nil || e.Encode(kv.windows) != nil ||
+		e.Encode(kv.config) != nil || e.Encode(kv.prevConfig) != nil || e.Encode(kv.states) != nil ||
+		e.Encode(kv.auth) != nil || e.Encode(kv.now) != nil || e.Encode(kv.nextSweep) != nil ||
+		e.Encode(kv.lastApplied) != nil {
+		panic("failed to encode some fields")
+	}
 	return w.Bytes()
 }
 
@@ -0,0 +1,157 @@
+package kvraft
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"DDB/client"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+// snapshotGroup is a group of servers that snapshot, which can be restarted from their persisters.
+type snapshotGroup struct {
+	t          *testing.T
+	net        *transport.Network
+	names      []string
+	servers    []*client.Client
+	persisters []*raft.Persister
+	kvs        []*KVServer
+	config     raft.Config
+}
+
+func startSnapshotGroup(t *testing.T, n int, maxraftstate int) *snapshotGroup {
+	g := &snapshotGroup{t: t, net: transport.MakeNetwork(), config: configWith(maxraftstate)}
+	for i := 0; i < n; i++ {
+		g.names = append(g.names, fmt.Sprint("s", i))
+		g.servers = append(g.servers, g.net.Dial(g.names[i]))
+		g.persisters = append(g.persisters, raft.MakePersister())
+		g.kvs = append(g.kvs, nil)
+	}
+	for i := range g.names {
+		g.start(i)
+	}
+	t.Cleanup(func() {
+		for _, kv := range g.kvs {
+			kv.Kill()
+		}
+	})
+	return g
+}
+
+// start starts server i from what its persister holds.
+func (g *snapshotGroup) start(i int) {
+	// note: a copy, since the killed server may still be saving.
+	g.persisters[i] = g.persisters[i].Copy()
+	g.kvs[i] = StartShardKVServerOn(g.net.From(g.names[i]), g.servers, i, g.persisters[i], g.config, g.names[i], 0, nil)
+}
+
+// writeTyped writes a value of each kind, and then enough others for the log to be snapshotted.
+func writeTyped(ck *Clerk) {
+	ck.Put("str", "v")
+	ck.HSet("hash", "f", "v")
+	ck.LPush("list", "a", "b")
+	ck.SAdd("set", "x", "y")
+	for i := 0; i < 50; i++ {
+		ck.Put(fmt.Sprint("filler", i), fmt.Sprintf("%050d", i))
+	}
+}
+
+// read reads what op would from the state machine of kv, whether it leads or not.
+func read(kv *KVServer, op Op) []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if op.Type == "Get" {
+		v, _ := kv.lookup(op.Key, StringValue, false)
+		if v == nil {
+			return nil
+		}
+		return []string{v.Str}
+	}
+	_, values := kv.applyTyped(&op)
+	return values
+}
+
+func checkTyped(t *testing.T, kv *KVServer) {
+	reads := []struct {
+		op   Op
+		want []string
+	}{
+		{Op{Type: "Get", Key: "str"}, []string{"v"}},
+		{Op{Type: "HGet", Key: "hash", Field: "f"}, []string{"v"}},
+		{Op{Type: "LRange", Key: "list", Start: 0, Stop: -1}, []string{"b", "a"}},
+		{Op{Type: "SMembers", Key: "set"}, []string{"x", "y"}},
+	}
+	for _, r := range reads {
+		if got := read(kv, r.op); !reflect.DeepEqual(got, r.want) {
+			t.Errorf("%s %s = %q, want %q", r.op.Type, r.op.Key, got, r.want)
+		}
+	}
+}
+
+func TestSnapshotRestart(t *testing.T) {
+	g := startSnapshotGroup(t, 3, 1000)
+	writeTyped(MakeClerk(g.servers))
+
+	for i, kv := range g.kvs {
+		kv.Kill()
+		if g.persisters[i].SnapshotSize() == 0 {
+			t.Fatalf("s%d took no snapshot", i)
+		}
+	}
+	for i := range g.kvs {
+		g.start(i)
+	}
+
+	// a write through the new leader has every server apply the log up to it.
+	ck := MakeClerk(g.servers)
+	ck.Put("after", "restart")
+	leader := leader(t, g.kvs, -1)
+	checkTyped(t, g.kvs[leader])
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	g := startSnapshotGroup(t, 3, 1000)
+	ck := MakeClerk(g.servers)
+	ck.Put("before", "v")
+
+	lagging := (leader(t, g.kvs, -1) + 1) % 3
+	g.net.Disconnect(g.names[lagging])
+	writeTyped(ck)
+	g.net.Connect(g.names[lagging])
+
+	// the entries it lacks are compacted by the others, so it's sent a snapshot.
+	ck.Put("after", "v")
+	for start := time.Now(); len(read(g.kvs[lagging], Op{Type: "Get", Key: "after"})) == 0; time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("s%d didn't catch up", lagging)
+		}
+	}
+	checkTyped(t, g.kvs[lagging])
+}
+
+func TestSnapshotThreshold(t *testing.T) {
+	const maxraftstate = 4000
+	g := startSnapshotGroup(t, 3, maxraftstate)
+	ck := MakeClerk(g.servers)
+	ck.Put("a", "1")
+	for i, ps := range g.persisters {
+		if ps.SnapshotSize() > 0 {
+			t.Fatalf("s%d snapshotted at a raft state of %d, below the threshold", i, ps.RaftStateSize())
+		}
+	}
+
+	writeTyped(ck)
+	for i, ps := range g.persisters {
+		for start := time.Now(); ps.SnapshotSize() == 0; time.Sleep(50 * time.Millisecond) {
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("s%d took no snapshot at a raft state of %d", i, ps.RaftStateSize())
+			}
+		}
+		if ps.RaftStateSize() > maxraftstate {
+			t.Fatalf("s%d has a raft state of %d past the max of %d", i, ps.RaftStateSize(), maxraftstate)
+		}
+	}
+}
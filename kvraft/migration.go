@@ -0,0 +1,268 @@
+package kvraft
+
+import (
+	"context"
+	"time"
+
+	"DDB/client"
+	btree "DDB/map"
+)
+
+// a shard owned under the current config is Serving unless it's being moved in,
+// and a shard not owned is either absent or Frozen.
+type ShardState int
+
+const (
+	Serving   ShardState = iota
+	Pulling              // owned, but its data is still held by the previous owner.
+	Frozen               // not owned anymore, kept until the new owner has pulled it.
+	Notifying            // pulled, waiting for the previous owner to delete its copy.
+)
+
+// stable tells whether every migration of the current config has completed,
+// which is required before moving on to the next config.
+func (kv *KVServer) stable() bool {
+	return len(kv.states) == 0
+}
+
+// serving tells whether the shard is owned and its data is in place.
+func (kv *KVServer) serving(shard int) bool {
+	if kv.config.Owner(shard) != kv.gid {
+		return false
+	}
+	state := kv.states[shard]
+	return state == Serving || state == Notifying
+}
+
+// transfer is a migration RPC in flight to group gid for config num, a pull or a delete.
+type transfer struct {
+	num  int
+	gid  int
+	pull bool
+}
+
+func (kv *KVServer) setState(shard int, state ShardState) {
+	if state == Serving {
+		delete(kv.states, shard)
+	} else {
+		kv.states[shard] = state
+	}
+}
+
+// migrate lets the leader pull the shards it gains and tell the previous owners
+// to delete the shards it has pulled.
+func (kv *KVServer) migrate() {
+	for !kv.Killed() {
+		if _, isLeader := kv.rf.GetState(); isLeader {
+			kv.mu.Lock()
+			pulls := kv.shardsFrom(Pulling)
+			notifies := kv.shardsFrom(Notifying)
+			num := kv.config.Num
+			kv.mu.Unlock()
+
+			for gid, shards := range pulls {
+				kv.startTransfer(transfer{num, gid, true}, shards)
+			}
+			for gid, shards := range notifies {
+				kv.startTransfer(transfer{num, gid, false}, shards)
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// startTransfer starts t unless it's still in flight from an earlier round.
+func (kv *KVServer) startTransfer(t transfer, shards []int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.transfers[t] {
+		return
+	}
+	kv.transfers[t] = true
+	go func() {
+		if t.pull {
+			kv.pullShards(t.num, t.gid, shards)
+		} else {
+			kv.deleteShards(t.num, t.gid, shards)
+		}
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+		delete(kv.transfers, t)
+	}()
+}
+
+// shardsFrom groups the shards in the given state by their owner in the previous config.
+func (kv *KVServer) shardsFrom(state ShardState) map[int][]int {
+	groups := make(map[int][]int)
+	for shard, s := range kv.states {
+		if s == state {
+			// note: a shard split in the current config was owned along with its parent.
+			start, _ := kv.config.Bounds(shard)
+			gid := kv.prevConfig.Owner(kv.prevConfig.Shard(start))
+			groups[gid] = append(groups[gid], shard)
+		}
+	}
+	return groups
+}
+
+func (kv *KVServer) groupServers(gid int) []*client.Client {
+	servers := []*client.Client{}
+	for _, address := range kv.prevConfig.Groups[gid] {
+		servers = append(servers, kv.transport.Dial(address))
+	}
+	return servers
+}
+
+// call sends an RPC to a server of another group, bounded by callTimeout.
+func (kv *KVServer) call(server *client.Client, rpcname string, args interface{}, reply interface{}) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return server.CallContext(ctx, rpcname, args, reply)
+}
+
+func (kv *KVServer) pullShards(num int, gid int, shards []int) {
+	args := PullShardArgs{ConfigNum: num, Shards: shards, Gid: gid}
+	for _, server := range kv.groupServers(gid) {
+		reply := PullShardReply{}
+		if kv.call(server, "KVServer.PullShard", &args, &reply) && reply.Err == OK {
+			op := Op{
+				Type:      "InstallShard",
+				ConfigNum: num,
+				ShardIds:  shards,
+				Shards:    reply.Shards,
+				Windows:   reply.Windows,
+			}
+			kv.rf.Start(&op)
+			return
+		}
+	}
+}
+
+func (kv *KVServer) deleteShards(num int, gid int, shards []int) {
+	args := DeleteShardArgs{ConfigNum: num, Shards: shards, Gid: gid}
+	for _, server := range kv.groupServers(gid) {
+		reply := DeleteShardReply{}
+		if kv.call(server, "KVServer.DeleteShard", &args, &reply) && reply.Err == OK {
+			op := Op{Type: "ShardDeleted", ConfigNum: num, ShardIds: shards}
+			kv.rf.Start(&op)
+			return
+		}
+	}
+}
+
+// PullShard hands over the frozen shards, along with the dedup table, to their new owner.
+func (kv *KVServer) PullShard(args *PullShardArgs, reply *PullShardReply) error {
+	if _, isLeader := kv.rf.GetState(); !isLeader {
+		reply.Err = ErrWrongLeader
+		return nil
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.config.Num < args.ConfigNum {
+		// the shards are not frozen yet.
+		reply.Err = ErrNotReady
+		return nil
+	}
+
+	reply.Shards = make(map[int]*btree.Map[string, *Value])
+	for _, shard := range args.Shards {
+		if db, ok := kv.db[shard]; ok {
+			// note: frozen shards are never written to, so the copy is only needed to be safe
+			// from deletion while the reply is encoded.
+			reply.Shards[shard] = db.Copy()
+		}
+	}
+	reply.Windows = make(map[int64]*Window)
+	for clerkId, w := range kv.windows {
+		reply.Windows[clerkId] = w.copy()
+	}
+	reply.Err = OK
+	return nil
+}
+
+// DeleteShard is called by the new owner once it has installed the shards.
+// it replies OK once the shards have been deleted.
+func (kv *KVServer) DeleteShard(args *DeleteShardArgs, reply *DeleteShardReply) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.config.Num > args.ConfigNum {
+		reply.Err = OK
+		return nil
+	}
+	if kv.config.Num < args.ConfigNum {
+		reply.Err = ErrNotReady
+		return nil
+	}
+	for _, shard := range args.Shards {
+		if kv.states[shard] == Frozen {
+			if !kv.start(&Op{Type: "DeleteShard", ConfigNum: args.ConfigNum, ShardIds: args.Shards}) {
+				reply.Err = ErrWrongLeader
+			} else {
+				reply.Err = ErrNotApplied
+			}
+			return nil
+		}
+	}
+	reply.Err = OK
+	return nil
+}
+
+func (kv *KVServer) applyInstallShard(op *Op) {
+	if op.ConfigNum != kv.config.Num {
+		return
+	}
+	installed := false
+	for _, shard := range op.ShardIds {
+		if kv.states[shard] != Pulling {
+			// installed already by a duplicated op.
+			continue
+		}
+		installed = true
+		if db, ok := op.Shards[shard]; ok {
+			// note: the op is still held by the raft log, which must not see the later writes.
+			kv.db[shard] = copyShard(db)
+		} else {
+			delete(kv.db, shard)
+		}
+		kv.setState(shard, Notifying)
+	}
+	if !installed {
+		return
+	}
+	for clerkId, w := range op.Windows {
+		kv.window(clerkId).merge(w)
+	}
+}
+
+// copyShard returns a deep copy of db, whose values are written in place.
+func copyShard(db *btree.Map[string, *Value]) *btree.Map[string, *Value] {
+	c := new(btree.Map[string, *Value])
+	db.Scan(func(key string, v *Value) bool {
+		c.Set(key, v.copy())
+		return true
+	})
+	return c
+}
+
+func (kv *KVServer) applyDeleteShard(op *Op) {
+	if op.ConfigNum != kv.config.Num {
+		return
+	}
+	for _, shard := range op.ShardIds {
+		if kv.states[shard] == Frozen {
+			delete(kv.db, shard)
+			kv.setState(shard, Serving)
+		}
+	}
+}
+
+func (kv *KVServer) applyShardDeleted(op *Op) {
+	if op.ConfigNum != kv.config.Num {
+		return
+	}
+	for _, shard := range op.ShardIds {
+		if kv.states[shard] == Notifying {
+			kv.setState(shard, Serving)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package kvraft
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"DDB/client"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+// startGroup starts a group of n servers on a simulated network, named s0, s1, ...
+func startGroup(t *testing.T, n int) (*transport.Network, []string, []*KVServer, []*client.Client) {
+	net := transport.MakeNetwork()
+	names := []string{}
+	servers := []*client.Client{}
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprint("s", i))
+		servers = append(servers, net.Dial(names[i]))
+	}
+	// note: no snapshots, see gc_test.go for those.
+	config := configWith(-1)
+	kvs := []*KVServer{}
+	for i, name := range names {
+		kvs = append(kvs, StartShardKVServerOn(net.From(name), servers, i, raft.MakePersister(), config, name, 0, nil))
+	}
+	t.Cleanup(func() {
+		for _, kv := range kvs {
+			kv.Kill()
+		}
+	})
+	return net, names, kvs, servers
+}
+
+// leader waits for a leader among the servers that aren't cut off, and returns it.
+func leader(t *testing.T, kvs []*KVServer, excluded int) int {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		for i, kv := range kvs {
+			if _, isLeader := kv.rf.GetState(); isLeader && i != excluded {
+				return i
+			}
+		}
+	}
+	t.Fatal("no leader elected")
+	return -1
+}
+
+func TestDisconnectedLeader(t *testing.T) {
+	net, names, kvs, servers := startGroup(t, 3)
+	ck := MakeClerk(servers)
+	ck.Put("a", "1")
+
+	old := leader(t, kvs, -1)
+	net.Disconnect(names[old])
+	ck.Append("a", "2")
+	if v := ck.Get("a"); v != "12" {
+		t.Fatalf("Get(a) = %q without the old leader, want %q", v, "12")
+	}
+	leader(t, kvs, old)
+
+	// the old leader steps down and catches up.
+	net.Connect(names[old])
+	ck.Append("a", "3")
+	if v := ck.Get("a"); v != "123" {
+		t.Fatalf("Get(a) = %q after reconnecting, want %q", v, "123")
+	}
+}
+
+func TestPartitionedLeader(t *testing.T) {
+	net, names, kvs, servers := startGroup(t, 5)
+	ck := MakeClerk(servers)
+	ck.Put("a", "x")
+
+	old := leader(t, kvs, -1)
+	minority := []string{names[old], names[(old+1)%5]}
+	majority := []string{names[(old+2)%5], names[(old+3)%5], names[(old+4)%5]}
+	net.Partition(minority, majority)
+
+	// the clerk reaches both sides, but only the majority can get anything done.
+	for i := 0; i < 10; i++ {
+		ck.Append("a", fmt.Sprint(i))
+	}
+	if v := ck.Get("a"); v != "x0123456789" {
+		t.Fatalf("Get(a) = %q during the partition, want %q", v, "x0123456789")
+	}
+
+	net.Partition()
+	ck.Append("a", "y")
+	if v := ck.Get("a"); v != "x0123456789y" {
+		t.Fatalf("Get(a) = %q after healing, want %q", v, "x0123456789y")
+	}
+}
+
+func TestUnreliableReordering(t *testing.T) {
+	net, _, _, servers := startGroup(t, 3)
+	net.Reliable(false)
+	net.LongReordering(true)
+
+	// each clerk appends to its own key; every append must be applied once, in order,
+	// despite the lost and late replies, and the retries they cause.
+	const clerks, appends = 3, 10
+	var wg sync.WaitGroup
+	for c := 0; c < clerks; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			ck := MakeClerk(servers)
+			for i := 0; i < appends; i++ {
+				ck.Append(fmt.Sprint("k", c), fmt.Sprint(i, ";"))
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	net.Reliable(true)
+	net.LongReordering(false)
+	ck := MakeClerk(servers)
+	for c := 0; c < clerks; c++ {
+		want := strings.Builder{}
+		for i := 0; i < appends; i++ {
+			want.WriteString(fmt.Sprint(i, ";"))
+		}
+		if v := ck.Get(fmt.Sprint("k", c)); v != want.String() {
+			t.Fatalf("Get(k%d) = %q, want %q", c, v, want.String())
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package kvraft
 
+import (
+	btree "DDB/map"
+	"DDB/shardctrler"
+)
+
 type Op struct {
 	// Your definitions here.
 	// Field names must start with capital letters,
@@ -9,4 +14,30 @@ type Op struct {
 	Type    string
 	ClerkId int64
 	OpId    int
+	Acked   int   // every op of the clerk below Acked is done with.
+	Time    int64 // the unix time in ms when the op was started, which decides expiry.
+
+	// arguments of the typed value operations.
+	Field  string
+	Values []string
+	Start  int
+	Stop   int
+	Limit  int
+	TTL    int64
+
+	// the config to install for "Config" ops.
+	Config shardctrler.Config
+
+	// arguments of the shard migration ops.
+	ConfigNum int
+	ShardIds  []int
+	Shards    map[int]*btree.Map[string, *Value]
+	Windows   map[int64]*Window
+}
+
+// Result is the outcome of an op, computed when it is applied.
+type Result struct {
+	OpId   int
+	Err    Err
+	Values []string
 }
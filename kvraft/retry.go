@@ -0,0 +1,48 @@
+package kvraft
+
+import (
+	"context"
+
+	"DDB/client"
+)
+
+// RetryPolicy tells how a clerk paces the rounds of an op that no server got done yet,
+// see client.RetryPolicy.
+type RetryPolicy = client.RetryPolicy
+
+// DefaultRetryPolicy retries for as long as it takes, as the clerk always did.
+var DefaultRetryPolicy = client.DefaultRetryPolicy
+
+// SetRetryPolicy sets the retry policy of every later op of the clerk, including the
+// queries to the shard controller.
+func (ck *Clerk) SetRetryPolicy(policy RetryPolicy) {
+	ck.policy = policy
+	if ck.ctrler != nil {
+		ck.ctrler.SetRetryPolicy(policy)
+	}
+}
+
+// retrier paces the rounds of a single op.
+type retrier struct {
+	*client.Retrier
+}
+
+func (ck *Clerk) retrier() retrier {
+	return retrier{client.NewRetrier(ck.policy)}
+}
+
+// wait is called after a failed round, and waits before the next one.
+// it returns ErrRetriesExhausted once the budget is spent, or the error of ctx once it's done.
+func (r retrier) wait(ctx context.Context) error {
+	if err := r.Wait(ctx); err != client.ErrRetriesExhausted {
+		return err
+	}
+	return Err(ErrRetriesExhausted)
+}
+
+// refresh fetches the latest config for the next round, within the budget of the op.
+func (r retrier) refresh(ctx context.Context, ck *Clerk) {
+	ctx, cancel := r.Bound(ctx)
+	defer cancel()
+	ck.refresh(ctx)
+}
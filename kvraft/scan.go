@@ -0,0 +1,38 @@
+package kvraft
+
+import (
+	"sort"
+	"strings"
+)
+
+// scan returns the string values of the keys starting with prefix, in key order, as
+// key, value pairs, leaving out the keys expired at now. at most limit keys are returned,
+// unless limit is 0. in a sharded deployment, only the shards served by this group are scanned.
+func (kv *KVServer) scan(prefix string, limit int, now int64) []string {
+	pairs := []KeyValue{}
+	for shard, db := range kv.db {
+		if kv.gid != 0 && !kv.serving(shard) {
+			continue
+		}
+		n := 0
+		db.Ascend(prefix, func(key string, v *Value) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			if v.Kind == StringValue && !v.expired(now) {
+				pairs = append(pairs, KeyValue{key, v.Str})
+				n++
+			}
+			return limit == 0 || n < limit
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	if limit > 0 && len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	values := make([]string, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		values = append(values, pair.Key, pair.Value)
+	}
+	return values
+}
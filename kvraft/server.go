@@ -5,13 +5,14 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"net"
-	"net/http"
 	"net/rpc"
 
+	"DDB/auth"
 	"DDB/client"
 	"DDB/labgob"
 	"DDB/raft"
+	"DDB/shardctrler"
+	"DDB/transport"
 
 	btree "DDB/map"
 )
@@ -23,17 +24,36 @@ type KVServer struct {
 	applyCh chan raft.ApplyMsg
 	dead    int32 // set by Kill()
 
-	maxraftstate int // snapshot if log grows this big
-	maxApplied   map[int64]int
-	persister    *raft.Persister
-	gc           bool
+	persister *raft.Persister
+	gc        bool // whether to snapshot, once the raft state nears MaxRaftState.
 
 	// Your definitions here.
 	// db       map[string]string
-	db       btree.Map[string, string]
-	notifier map[int64]*Notifier
+	db      map[int]*btree.Map[string, *Value] // shard -> db
+	windows map[int64]*Window                  // clerk id -> the ops of the clerk that have been applied.
+	waiters map[int]*waiter                    // log index -> the waiter of the op started at the index.
+	auth    *auth.Store                        // the users, those of the current config if sharded.
 
-	port string
+	// the log time in ms, i.e. the latest time stamped in an applied op, by which sessions expire.
+	now       int64
+	nextSweep int64 // the log time at which the expired sessions are dropped next.
+
+	lastApplied int // the log index of the last op applied, or of the snapshot installed.
+
+	// set if the server belongs to replica group gid of a sharded deployment.
+	gid        int
+	ctrler     *shardctrler.Clerk
+	config     shardctrler.Config
+	prevConfig shardctrler.Config
+	states     map[int]ShardState // shard -> state, for the shards being migrated.
+	load       map[int]int        // shard -> ops started since the last split check.
+	transfers  map[transfer]bool  // the migration RPCs in flight.
+	splitNum   int                // the config num when a split was last asked for.
+
+	watchers map[*watcher]bool
+
+	transport transport.Transport
+	port      string // the address served on.
 }
 
 func (kv *KVServer) Get(args *GetArgs, reply *GetReply) error {
@@ -41,11 +61,21 @@ func (kv *KVServer) Get(args *GetArgs, reply *GetReply) error {
 	op := Op{}
 	op.ClerkId = args.ClerkId
 	op.OpId = args.OpId
+	op.Acked = args.Acked
 	op.Key = args.Key
 	op.Type = "Get"
-	err, value := kv.waitApply(&op)
-	reply.Value = value
+	if err := kv.authorize(args.Token, op.Key, auth.Read); err != OK {
+		reply.Err = err
+		return nil
+	}
+	err, values := kv.waitApply(&op)
+	if len(values) > 0 {
+		reply.Value = values[0]
+	}
 	reply.Err = err
+	if err == ErrWrongLeader {
+		reply.Hint = kv.hint()
+	}
 	return nil
 }
 
@@ -54,11 +84,70 @@ func (kv *KVServer) PutAppend(args *PutAppendArgs, reply *PutAppendReply) error
 	op := Op{}
 	op.ClerkId = args.ClerkId
 	op.OpId = args.OpId
+	op.Acked = args.Acked
 	op.Key = args.Key
 	op.Value = args.Value
 	op.Type = args.Op
+	if err := kv.authorize(args.Token, op.Key, auth.Write); err != OK {
+		reply.Err = err
+		return nil
+	}
 	err, _ := kv.waitApply(&op)
 	reply.Err = err
+	if err == ErrWrongLeader {
+		reply.Hint = kv.hint()
+	}
+	return nil
+}
+
+func (kv *KVServer) Command(args *CommandArgs, reply *CommandReply) error {
+	op := Op{}
+	op.ClerkId = args.ClerkId
+	op.OpId = args.OpId
+	op.Acked = args.Acked
+	op.Key = args.Key
+	op.Type = args.Op
+	op.Field = args.Field
+	op.Value = args.Value
+	op.Values = args.Values
+	op.Start = args.Start
+	op.Stop = args.Stop
+	op.Limit = args.Limit
+	op.TTL = args.TTL
+	if isSessionOp(op.Type) {
+		// note: a session id may be picked by the clerk, or else by the server.
+		if op.Type == "RegisterClient" && op.ClerkId == 0 {
+			op.ClerkId = nrand()
+		}
+		// a session is for known users only, whatever their permissions.
+		if err := kv.authorize(args.Token, "", auth.NoPermission); err != OK {
+			reply.Err = err
+			return nil
+		}
+	} else if auth.IsOp(op.Type) {
+		if kv.gid != 0 {
+			// the users of a sharded deployment are kept by the shard controller.
+			reply.Err = ErrWrongGroup
+			return nil
+		}
+		// note: only the hash of a secret is ever replicated.
+		if op.Type == "AddUser" {
+			op.Value = auth.HashSecret(op.Value)
+		}
+		if err := kv.authorize(args.Token, "", auth.Admin); err != OK {
+			reply.Err = err
+			return nil
+		}
+	} else if err := kv.authorize(args.Token, op.Key, required(op.Type)); err != OK {
+		reply.Err = err
+		return nil
+	}
+	err, values := kv.waitApply(&op)
+	reply.Err = err
+	reply.Values = values
+	if err == ErrWrongLeader {
+		reply.Hint = kv.hint()
+	}
 	return nil
 }
 
@@ -68,6 +157,72 @@ func StartKVServer(
 	persister *raft.Persister,
 	maxraftstate int,
 	port string,
+) *KVServer {
+	return StartShardKVServer(servers, me, persister, maxraftstate, port, 0, nil)
+}
+
+// configWith returns the default raft config, with maxraftstate.
+func configWith(maxraftstate int) raft.Config {
+	config := raft.DefaultConfig
+	config.MaxRaftState = maxraftstate
+	return config
+}
+
+// StartShardKVServer starts a server of the replica group gid, which serves the shards
+// that the shard controller made of ctrlers assigns to the group.
+func StartShardKVServer(
+	servers []*client.Client,
+	me int,
+	persister *raft.Persister,
+	maxraftstate int,
+	port string,
+	gid int,
+	ctrlers []*client.Client,
+) *KVServer {
+	return StartShardKVServerOn(transport.TCP, servers, me, persister, configWith(maxraftstate), port, gid, ctrlers)
+}
+
+// StartShardKVServerOn is StartShardKVServer over the transport t, on which the server
+// serves at address, with the raft config config, which tells when to snapshot too.
+func StartShardKVServerOn(
+	t transport.Transport,
+	servers []*client.Client,
+	me int,
+	persister *raft.Persister,
+	config raft.Config,
+	address string,
+	gid int,
+	ctrlers []*client.Client,
+) *KVServer {
+	kv := startKVServer(t, servers, me, persister, config, gid, ctrlers, false)
+	kv.port = address
+	kv.server(kv.rf)
+	return kv
+}
+
+// StartHostedKVServer starts a server of the replica group gid inside a multi-raft host,
+// which serves the RPCs of the server and of its raft, and drives the raft.
+func StartHostedKVServer(
+	t transport.Transport,
+	servers []*client.Client,
+	me int,
+	persister *raft.Persister,
+	config raft.Config,
+	gid int,
+	ctrlers []*client.Client,
+) *KVServer {
+	return startKVServer(t, servers, me, persister, config, gid, ctrlers, true)
+}
+
+func startKVServer(
+	t transport.Transport,
+	servers []*client.Client,
+	me int,
+	persister *raft.Persister,
+	config raft.Config,
+	gid int,
+	ctrlers []*client.Client,
+	hosted bool,
 ) *KVServer {
 	// call labgob.Register on structures you want
 	// Go's RPC library to marshall/unmarshall.
@@ -75,49 +230,136 @@ func StartKVServer(
 
 	kv := new(KVServer)
 	kv.me = me
-	kv.maxraftstate = maxraftstate
 	kv.mu = sync.Mutex{}
 
-	kv.port = port
+	kv.gid = gid
+	kv.transport = t
+	servers = transport.Redial(t, servers)
 
 	// You may need initialization code here.
 
 	kv.applyCh = make(chan raft.ApplyMsg)
-	kv.rf = raft.Make(servers, me, persister, kv.applyCh)
-	kv.gc = maxraftstate != -1
+	if hosted {
+		kv.rf = raft.MakeHosted(servers, me, persister, kv.applyCh, config, gid)
+	} else {
+		kv.rf = raft.Make(servers, me, persister, kv.applyCh, config)
+	}
+	kv.gc = config.MaxRaftState != -1
 	kv.persister = persister
 
 	if kv.gc && kv.persister.SnapshotSize() > 0 {
 		kv.ingestSnapshot(kv.persister.ReadSnapshot())
 
 	} else {
-		kv.maxApplied = make(map[int64]int)
+		kv.db = make(map[int]*btree.Map[string, *Value])
+		kv.windows = make(map[int64]*Window)
+		kv.states = make(map[int]ShardState)
+		kv.auth = auth.MakeStore()
 	}
 
 	// You may need initialization code here.
-	kv.notifier = make(map[int64]*Notifier)
+	kv.waiters = make(map[int]*waiter)
+	kv.load = make(map[int]int)
+	kv.transfers = make(map[transfer]bool)
+	kv.watchers = make(map[*watcher]bool)
 
 	go kv.applier()
-
-	kv.server(kv.rf)
+	if kv.gid != 0 {
+		kv.ctrler = shardctrler.MakeClerk(transport.Redial(t, ctrlers))
+		go kv.pollConfig()
+		go kv.migrate()
+		go kv.splitter()
+	}
 
 	return kv
 }
 
+func (kv *KVServer) hint() Hint {
+	leaderId, term, leader := kv.rf.LeaderHint()
+	return Hint{LeaderId: leaderId, Term: term, Leader: leader}
+}
+
+func (kv *KVServer) Raft() *raft.Raft {
+	return kv.rf
+}
+
+// server serves all the RPCs to the peers of the group, the shard migration and the key
+// operations to the servers of the other groups, and only the key operations to clients.
 func (kvrf *KVServer) server(rf *raft.Raft) {
-	if rpc.Register(kvrf) != nil {
+	peers := rpc.NewServer()
+	if peers.Register(kvrf) != nil {
+		log.Fatal("error")
+	}
+	if peers.Register(rf) != nil {
+		log.Fatal("error")
+	}
+	groups := rpc.NewServer()
+	if groups.RegisterName("KVServer", &GroupAPI{ClientAPI{kvrf}}) != nil {
 		log.Fatal("error")
 	}
-	if rpc.Register(rf) != nil {
+	clients := rpc.NewServer()
+	if clients.RegisterName("KVServer", &ClientAPI{kvrf}) != nil {
 		log.Fatal("error")
 	}
-	rpc.HandleHTTP()
-	port := ":" + kvrf.port
-	l, e := net.Listen("tcp", port)
+	e := kvrf.transport.Serve(kvrf.port, clients,
+		transport.Tier{Server: peers, Members: kvrf.Peers},
+		transport.Tier{Server: groups, Members: kvrf.Others})
 	if e != nil {
 		log.Fatal(e)
 	}
-	go http.Serve(l, nil)
+}
+
+// Peers returns the addresses of the peers of the group.
+func (kv *KVServer) Peers() []string {
+	peers := []string{}
+	for _, peer := range kv.rf.Peers() {
+		peers = append(peers, peer.Address())
+	}
+	return peers
+}
+
+// Others returns the addresses of the servers of the other groups, as far as known.
+func (kv *KVServer) Others() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	others := []string{}
+	for gid, servers := range kv.config.Groups {
+		if gid != kv.gid {
+			others = append(others, servers...)
+		}
+	}
+	return others
+}
+
+// ClientAPI is the part of the RPCs of a KVServer that clients may call.
+type ClientAPI struct {
+	kv *KVServer
+}
+
+// GroupAPI is the part of the RPCs of a KVServer that the servers of the other groups may
+// call: the key operations, e.g. for the clerks of their gateways, and the shard migration.
+type GroupAPI struct {
+	ClientAPI
+}
+
+func (api *GroupAPI) PullShard(args *PullShardArgs, reply *PullShardReply) error {
+	return api.kv.PullShard(args, reply)
+}
+
+func (api *GroupAPI) DeleteShard(args *DeleteShardArgs, reply *DeleteShardReply) error {
+	return api.kv.DeleteShard(args, reply)
+}
+
+func (api *ClientAPI) Get(args *GetArgs, reply *GetReply) error {
+	return api.kv.Get(args, reply)
+}
+
+func (api *ClientAPI) PutAppend(args *PutAppendArgs, reply *PutAppendReply) error {
+	return api.kv.PutAppend(args, reply)
+}
+
+func (api *ClientAPI) Command(args *CommandArgs, reply *CommandReply) error {
+	return api.kv.Command(args, reply)
 }
 
 func (kv *KVServer) Kill() {
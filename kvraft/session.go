@@ -0,0 +1,82 @@
+package kvraft
+
+//
+// client sessions, as in §6.3 of the raft dissertation. a clerk registers a session
+// with each group it talks to, and the group keeps the dedup window of the clerk for
+// as long as the session lives. every op of the clerk, or a keep-alive, extends it.
+// a session expires after sessionTimeout without any op, by the times stamped in the
+// log, so that every server drops it at the same point of the log. the ops of an expired
+// session are refused with ErrSessionExpired, since they may have been applied already.
+//
+// ops without a session (ClerkId 0), e.g. those of the gRPC, REST and RESP servers,
+// aren't deduplicated: each is applied on its own, and no state is kept for it.
+//
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	sessionTimeout = 5 * time.Minute
+	sweepInterval  = 1 * time.Second // how often the expired sessions are dropped, in log time.
+)
+
+func isSessionOp(op string) bool {
+	return op == "RegisterClient" || op == "KeepAlive"
+}
+
+// session returns the window of the clerk of op, and extends its session.
+// it returns nil if the session expired, in which case the op is refused.
+// a session past its deadline is expired even if it hasn't been swept yet, so that
+// the outcome doesn't depend on when the sweeps happen. the caller has ticked to op.Time.
+func (kv *KVServer) session(op *Op) *Window {
+	w, ok := kv.windows[op.ClerkId]
+	if ok && w.Deadline < kv.now {
+		delete(kv.windows, op.ClerkId)
+		ok = false
+	}
+	if !ok {
+		if op.Type != "RegisterClient" {
+			return nil
+		}
+		w = makeWindow()
+		kv.windows[op.ClerkId] = w
+	}
+	w.Deadline = max64(w.Deadline, op.Time+sessionTimeout.Milliseconds())
+	return w
+}
+
+// applySession applies a "RegisterClient" or "KeepAlive" op, whose session
+// has been made or extended already.
+func (kv *KVServer) applySession(op *Op) Result {
+	result := Result{OpId: op.OpId, Err: OK}
+	if op.Type == "RegisterClient" {
+		result.Values = []string{strconv.FormatInt(op.ClerkId, 10)}
+	}
+	return result
+}
+
+// tick advances the log time to t, and drops the sessions that expired every sweepInterval.
+func (kv *KVServer) tick(t int64) {
+	if t <= kv.now {
+		return
+	}
+	kv.now = t
+	if kv.now < kv.nextSweep {
+		return
+	}
+	for clerkId, w := range kv.windows {
+		if w.Deadline < kv.now {
+			delete(kv.windows, clerkId)
+		}
+	}
+	kv.nextSweep = kv.now + sweepInterval.Milliseconds()
+}
+
+func max64(a int64, b int64) int64 {
+	if a >= b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,26 @@
+package kvraft
+
+import "testing"
+
+// a session is expired as soon as its deadline is past, not only once it's swept.
+func TestSessionExpiresBeforeSweep(t *testing.T) {
+	kv := &KVServer{windows: make(map[int64]*Window)}
+	kv.tick(1)
+	if kv.session(&Op{Type: "RegisterClient", ClerkId: 1, Time: 1}) == nil {
+		t.Fatal("the session wasn't registered")
+	}
+	deadline := kv.windows[1].Deadline
+
+	// a sweep just before the deadline, and an op just after it, before the next sweep.
+	kv.tick(deadline - 1)
+	kv.tick(deadline + 1)
+	if _, ok := kv.windows[1]; !ok {
+		t.Fatal("the session was swept already")
+	}
+	if kv.session(&Op{Type: "Put", ClerkId: 1, Time: deadline + 1}) != nil {
+		t.Fatal("an op extended an expired session")
+	}
+	if _, ok := kv.windows[1]; ok {
+		t.Fatal("the expired session was kept")
+	}
+}
@@ -0,0 +1,74 @@
+package kvraft
+
+import (
+	"time"
+
+	btree "DDB/map"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// store returns the db of the shard that key belongs to.
+func (kv *KVServer) store(key string) *btree.Map[string, *Value] {
+	shard := kv.config.Shard(key)
+	db, ok := kv.db[shard]
+	if !ok {
+		db = new(btree.Map[string, *Value])
+		kv.db[shard] = db
+	}
+	return db
+}
+
+// owns tells whether this group is in charge of key and holds its data.
+// an unsharded server, i.e. with gid 0, owns all keys.
+func (kv *KVServer) owns(key string) bool {
+	return kv.gid == 0 || kv.serving(kv.config.Shard(key))
+}
+
+// pollConfig lets the leader propose the configs of the shard controller one by one.
+// a config is only proposed after all the migrations of the previous one are done.
+func (kv *KVServer) pollConfig() {
+	for !kv.Killed() {
+		if _, isLeader := kv.rf.GetState(); isLeader {
+			kv.mu.Lock()
+			num := kv.config.Num
+			stable := kv.stable()
+			kv.mu.Unlock()
+
+			config := kv.ctrler.Query(num + 1)
+			if stable && config.Num == num+1 {
+				kv.rf.Start(&Op{Type: "Config", Config: config})
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// applyConfig installs the next config. shards gained from another group start to be pulled
+// and shards lost are frozen, i.e. no longer served but kept until the new owner pulls them.
+// a shard split in this config is carved out of its parent first.
+func (kv *KVServer) applyConfig(op *Op) {
+	// configs must be installed in order, and a config may be proposed more than once.
+	if op.Config.Num != kv.config.Num+1 || !kv.stable() {
+		return
+	}
+	for _, r := range op.Config.Ranges {
+		// a shard comes either from itself or from the shard it's been split from.
+		parent := kv.config.Shard(r.Start)
+		before := kv.config.Owner(parent)
+		if parent != r.Id && before == kv.gid {
+			kv.split(parent, r.Id, r.Start)
+		}
+		after := r.Gid
+		if before != kv.gid && after == kv.gid && before != 0 {
+			kv.setState(r.Id, Pulling)
+		} else if before == kv.gid && after != kv.gid {
+			kv.setState(r.Id, Frozen)
+		}
+	}
+	kv.prevConfig = kv.config
+	kv.config = op.Config
+	if op.Config.Auth != nil {
+		kv.auth = op.Config.Auth
+	}
+}
@@ -0,0 +1,75 @@
+package kvraft
+
+import (
+	"time"
+
+	btree "DDB/map"
+)
+
+// the leader asks the shard controller to split a shard once it holds more than splitSize keys,
+// or serves more than splitQPS ops per second.
+const (
+	splitSize     = 100000
+	splitQPS      = 1000
+	splitInterval = 1 * time.Second
+)
+
+// splitter periodically checks the load of the shards served by the group.
+func (kv *KVServer) splitter() {
+	for !kv.Killed() {
+		time.Sleep(splitInterval)
+		_, isLeader := kv.rf.GetState()
+
+		kv.mu.Lock()
+		shard, key, found := 0, "", false
+		if isLeader {
+			shard, key, found = kv.findSplit()
+		}
+		kv.load = make(map[int]int)
+		if found {
+			kv.splitNum = kv.config.Num
+		}
+		kv.mu.Unlock()
+
+		if found {
+			kv.ctrler.Split(shard, key)
+		}
+	}
+}
+
+// findSplit returns a shard to split, and its median key to split it at.
+func (kv *KVServer) findSplit() (int, string, bool) {
+	// wait for a previous split to show up in the config, so that a shard isn't split twice.
+	if !kv.stable() || kv.splitNum >= kv.config.Num {
+		return 0, "", false
+	}
+	for shard, db := range kv.db {
+		if !kv.serving(shard) || db.Len() < 2 {
+			continue
+		}
+		qps := float64(kv.load[shard]) / splitInterval.Seconds()
+		if db.Len() > splitSize || qps > splitQPS {
+			// the nodes of the btree keep their counts, so the median is found in O(log n).
+			key, _, _ := db.GetAt(db.Len() / 2)
+			return shard, key, true
+		}
+	}
+	return 0, "", false
+}
+
+// split moves the keys of shard from key onwards into the new shard id.
+func (kv *KVServer) split(shard int, id int, key string) {
+	upper := new(btree.Map[string, *Value])
+	if db, ok := kv.db[shard]; ok {
+		db.Ascend(key, func(k string, v *Value) bool {
+			// keys are visited in order, hence the bulk loading.
+			upper.Load(k, v)
+			return true
+		})
+		upper.Scan(func(k string, v *Value) bool {
+			db.Delete(k)
+			return true
+		})
+	}
+	kv.db[id] = upper
+}
@@ -0,0 +1,237 @@
+package kvraft
+
+import "sort"
+
+type ValueKind int
+
+const (
+	StringValue ValueKind = iota
+	HashValue
+	ListValue
+	SetValue
+)
+
+// Value is the typed envelope stored under every key of the db.
+// only the field matching Kind is in use.
+type Value struct {
+	Kind ValueKind
+	Str  string
+	Hash map[string]string
+	Set  map[string]bool
+
+	// a list is a deque, so that both of its ends are O(1): Head holds its front reversed,
+	// and Tail its back, i.e. the list is Head[len(Head)-1], ..., Head[0], Tail[0], ...
+	Head []string
+	Tail []string
+
+	Deadline int64 // the unix time in ms at which the key expires, 0 if it never does.
+}
+
+func makeValue(kind ValueKind) *Value {
+	v := &Value{Kind: kind}
+	switch kind {
+	case HashValue:
+		v.Hash = make(map[string]string)
+	case SetValue:
+		v.Set = make(map[string]bool)
+	}
+	return v
+}
+
+func (v *Value) copy() *Value {
+	c := *v
+	if v.Hash != nil {
+		c.Hash = make(map[string]string, len(v.Hash))
+		for field, value := range v.Hash {
+			c.Hash[field] = value
+		}
+	}
+	if v.Set != nil {
+		c.Set = make(map[string]bool, len(v.Set))
+		for member := range v.Set {
+			c.Set[member] = true
+		}
+	}
+	c.Head = append([]string(nil), v.Head...)
+	c.Tail = append([]string(nil), v.Tail...)
+	return &c
+}
+
+func (v *Value) empty() bool {
+	switch v.Kind {
+	case HashValue:
+		return len(v.Hash) == 0
+	case ListValue:
+		return v.length() == 0
+	case SetValue:
+		return len(v.Set) == 0
+	}
+	return false
+}
+
+func (v *Value) length() int {
+	return len(v.Head) + len(v.Tail)
+}
+
+// at returns the i-th element of a list.
+func (v *Value) at(i int) string {
+	if i < len(v.Head) {
+		return v.Head[len(v.Head)-1-i]
+	}
+	return v.Tail[i-len(v.Head)]
+}
+
+func (v *Value) pushFront(value string) {
+	v.Head = append(v.Head, value)
+}
+
+// popBack removes the last element of a non-empty list.
+func (v *Value) popBack() string {
+	if len(v.Tail) > 0 {
+		last := v.Tail[len(v.Tail)-1]
+		v.Tail = v.Tail[:len(v.Tail)-1]
+		return last
+	}
+	last := v.Head[0]
+	v.Head = v.Head[1:]
+	return last
+}
+
+// lookup returns the value of the given kind stored under key.
+// if the key does not exist and create is set, a new empty value is inserted.
+func (kv *KVServer) lookup(key string, kind ValueKind, create bool) (*Value, Err) {
+	v, ok := kv.store(key).Get(key)
+	if !ok {
+		if !create {
+			return nil, OK
+		}
+		v = makeValue(kind)
+		kv.store(key).Set(key, v)
+		return v, OK
+	}
+	if v.Kind != kind {
+		return nil, ErrWrongType
+	}
+	return v, OK
+}
+
+// drop deletes the key once a container value becomes empty, as redis does.
+func (kv *KVServer) drop(key string, v *Value) {
+	if v.empty() {
+		kv.store(key).Delete(key)
+	}
+}
+
+// resolve converts a redis-style inclusive range, where negative indices count from the end,
+// into a slice range [start, stop) of a list of length n.
+func resolve(start int, stop int, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+func (kv *KVServer) applyTyped(op *Op) (Err, []string) {
+	switch op.Type {
+	case "HSet":
+		v, err := kv.lookup(op.Key, HashValue, true)
+		if err != OK {
+			return err, nil
+		}
+		v.Hash[op.Field] = op.Value
+
+	case "HGet":
+		v, err := kv.lookup(op.Key, HashValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		if value, ok := v.Hash[op.Field]; ok {
+			return OK, []string{value}
+		}
+
+	case "HDel":
+		v, err := kv.lookup(op.Key, HashValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		delete(v.Hash, op.Field)
+		kv.drop(op.Key, v)
+
+	case "LPush":
+		v, err := kv.lookup(op.Key, ListValue, true)
+		if err != OK {
+			return err, nil
+		}
+		for _, value := range op.Values {
+			v.pushFront(value)
+		}
+
+	case "RPop":
+		v, err := kv.lookup(op.Key, ListValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		last := v.popBack()
+		kv.drop(op.Key, v)
+		return OK, []string{last}
+
+	case "LRange":
+		v, err := kv.lookup(op.Key, ListValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		start, stop := resolve(op.Start, op.Stop, v.length())
+		values := make([]string, 0, stop-start)
+		for i := start; i < stop; i++ {
+			values = append(values, v.at(i))
+		}
+		return OK, values
+
+	case "SAdd":
+		v, err := kv.lookup(op.Key, SetValue, true)
+		if err != OK {
+			return err, nil
+		}
+		for _, member := range op.Values {
+			v.Set[member] = true
+		}
+
+	case "SRem":
+		v, err := kv.lookup(op.Key, SetValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		for _, member := range op.Values {
+			delete(v.Set, member)
+		}
+		kv.drop(op.Key, v)
+
+	case "SMembers":
+		v, err := kv.lookup(op.Key, SetValue, false)
+		if err != OK || v == nil {
+			return err, nil
+		}
+		members := make([]string, 0, len(v.Set))
+		for member := range v.Set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return OK, members
+
+	default:
+		return ErrUnknownOp, nil
+	}
+	return OK, nil
+}
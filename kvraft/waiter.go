@@ -0,0 +1,48 @@
+package kvraft
+
+import "time"
+
+const maxWaitTime = 500 * time.Millisecond
+
+// waiter waits for the op started at a log index in a term,
+// and receives the result computed when the op is applied.
+type waiter struct {
+	term int
+	done chan Result
+}
+
+// wait waits until the op started at index in term is applied, and returns its result.
+// it returns ErrWrongLeader if another op is applied at index, i.e. the leadership was lost
+// in the meantime, or ErrNotApplied once maxWaitTime is over. the caller holds kv.mu.
+func (kv *KVServer) wait(index int, term int) Result {
+	w := &waiter{term: term, done: make(chan Result, 1)}
+	kv.waiters[index] = w
+	kv.mu.Unlock()
+
+	timer := time.NewTimer(maxWaitTime)
+	defer timer.Stop()
+	result := Result{Err: ErrNotApplied}
+	select {
+	case result = <-w.done:
+	case <-timer.C:
+	}
+
+	kv.mu.Lock()
+	if kv.waiters[index] == w {
+		delete(kv.waiters, index)
+	}
+	return result
+}
+
+// deliver hands the result of the op applied at index in term to its waiter, if any.
+func (kv *KVServer) deliver(index int, term int, result Result) {
+	w, ok := kv.waiters[index]
+	if !ok {
+		return
+	}
+	delete(kv.waiters, index)
+	if w.term != term {
+		result = Result{Err: ErrWrongLeader}
+	}
+	w.done <- result
+}
@@ -0,0 +1,73 @@
+package kvraft
+
+import (
+	"strings"
+
+	"DDB/auth"
+)
+
+// watchBuffer is the number of events a watcher may lag behind the applier.
+const watchBuffer = 256
+
+// Event is a change to a key, seen by the watchers of a prefix of the key.
+type Event struct {
+	Key     string
+	Op      string // the op that changed the key.
+	Value   string // the new value, if it's a string.
+	Deleted bool
+}
+
+type watcher struct {
+	prefix string
+	events chan Event
+}
+
+// Watch returns the changes to the keys starting with prefix, as they're applied by this
+// server, along with a function to stop watching. the holder of token must be allowed to
+// read the prefix. the events are closed if the watcher falls too far behind.
+func (kv *KVServer) Watch(token string, prefix string) (<-chan Event, func(), Err) {
+	if err := kv.authorize(token, prefix, auth.Read); err != OK {
+		return nil, nil, err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	w := &watcher{prefix, make(chan Event, watchBuffer)}
+	kv.watchers[w] = true
+	cancel := func() {
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+		kv.unwatch(w)
+	}
+	return w.events, cancel, OK
+}
+
+func (kv *KVServer) unwatch(w *watcher) {
+	if kv.watchers[w] {
+		delete(kv.watchers, w)
+		close(w.events)
+	}
+}
+
+// publish tells the watchers of the key of op about the change that op just made.
+func (kv *KVServer) publish(op *Op) {
+	if len(kv.watchers) == 0 {
+		return
+	}
+	event := Event{Key: op.Key, Op: op.Type}
+	if v, ok := kv.store(op.Key).Get(op.Key); !ok {
+		event.Deleted = true
+	} else if v.Kind == StringValue {
+		event.Value = v.Str
+	}
+	for w := range kv.watchers {
+		if !strings.HasPrefix(op.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			// dropped rather than stalling the applier.
+			kv.unwatch(w)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package kvraft
+
+import (
+	"log"
+	"net/rpc"
+
+	"DDB/client"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+// StartWitness starts a witness among servers, a raft that votes and counts toward the
+// quorums of the group but keeps no commands, and serves no state machine and no clients.
+// it serves the raft RPCs at address over t.
+func StartWitness(
+	t transport.Transport,
+	servers []*client.Client,
+	me int,
+	persister *raft.Persister,
+	config raft.Config,
+	address string,
+) *raft.Raft {
+	config.Witness = true
+	// note: nothing is ever sent on applyCh by a witness.
+	rf := raft.Make(transport.Redial(t, servers), me, persister, make(chan raft.ApplyMsg), config)
+
+	peers := rpc.NewServer()
+	if peers.Register(rf) != nil {
+		log.Fatal("error")
+	}
+	members := func() []string {
+		members := []string{}
+		for _, peer := range rf.Peers() {
+			members = append(members, peer.Address())
+		}
+		return members
+	}
+	if e := t.Serve(address, rpc.NewServer(), transport.Tier{Server: peers, Members: members}); e != nil {
+		log.Fatal(e)
+	}
+	return rf
+}
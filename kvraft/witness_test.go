@@ -0,0 +1,58 @@
+package kvraft
+
+import (
+	"testing"
+	"time"
+
+	"DDB/client"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+// two servers and a witness carry on writing once the leader is lost, and once it's back.
+func TestWitnessFailover(t *testing.T) {
+	net := transport.MakeNetwork()
+	names := []string{"s0", "s1", "w"}
+	servers := []*client.Client{}
+	for _, name := range names {
+		servers = append(servers, net.Dial(name))
+	}
+	config := configWith(-1)
+	kvs := []*KVServer{}
+	for i, name := range names[:2] {
+		kvs = append(kvs, StartShardKVServerOn(net.From(name), servers, i, raft.MakePersister(), config, name, 0, nil))
+	}
+	w := StartWitness(net.From("w"), servers, 2, raft.MakePersister(), config, "w")
+	t.Cleanup(func() {
+		for _, kv := range kvs {
+			kv.Kill()
+		}
+		w.Kill()
+	})
+
+	// the witness serves no clients.
+	ck := MakeClerk(servers[:2])
+	ck.Put("a", "1")
+
+	old := leader(t, kvs, -1)
+	net.Disconnect(names[old])
+	for _, v := range []string{"2", "3", "4"} {
+		ck.Append("a", v)
+	}
+	if v := ck.Get("a"); v != "1234" {
+		t.Fatalf("Get(a) = %q without the old leader, want %q", v, "1234")
+	}
+
+	// the old leader catches up, and then the new one can be lost in turn.
+	net.Connect(names[old])
+	ck.Append("a", "5")
+	if v := ck.Get("a"); v != "12345" {
+		t.Fatalf("Get(a) = %q after reconnecting, want %q", v, "12345")
+	}
+	time.Sleep(time.Second)
+	net.Disconnect(names[leader(t, kvs, -1)])
+	ck.Append("a", "6")
+	if v := ck.Get("a"); v != "123456" {
+		t.Fatalf("Get(a) = %q without the second leader, want %q", v, "123456")
+	}
+}
@@ -3,17 +3,33 @@ package main
 import (
 	"DDB/client"
 	"DDB/kvraft"
+	"DDB/secure"
+	"DDB/shardctrler"
 	"bufio"
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
 func main() {
+	caFile := flag.String("ca", "", "CA certificate, to enable mutual TLS")
+	certFile := flag.String("cert", "", "certificate of this client")
+	keyFile := flag.String("key", "", "key of the certificate")
+	flag.Parse()
+	if *caFile != "" {
+		if err := secure.Setup(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 	clients := []*client.Client{}
+	ctrlers := []*client.Client{}
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Welcome to DDB client!")
 	fmt.Println("Please input the IP and port of the servers, use 'a IP port' to add server, use 'done' to finish.")
+	fmt.Println("For a sharded deployment, use 'c IP port' to add shard controllers instead.")
 	for {
 		fmt.Println("-> ")
 		text, _ := reader.ReadString('\n')
@@ -23,7 +39,7 @@ func main() {
 			fmt.Println("done")
 			break
 		}
-		if texts[0] == "a" {
+		if texts[0] == "a" || texts[0] == "c" {
 			if len(texts) < 2 {
 				fmt.Println("Need IP")
 				continue
@@ -35,12 +51,20 @@ func main() {
 			ip := texts[1]
 			port := texts[2]
 			cl := client.MakeClient(ip, port)
-			clients = append(clients, cl)
+			if texts[0] == "a" {
+				clients = append(clients, cl)
+			} else {
+				ctrlers = append(ctrlers, cl)
+			}
 		}
 	}
-	client := kvraft.MakeClerk(clients)
 	op := Operator{}
-	op.client = client
+	if len(ctrlers) > 0 {
+		op.client = kvraft.MakeShardClerk(ctrlers)
+		op.ctrler = shardctrler.MakeClerk(ctrlers)
+	} else {
+		op.client = kvraft.MakeClerk(clients)
+	}
 	for {
 		fmt.Println("-> ")
 		text, _ := reader.ReadString('\n')
@@ -65,12 +89,182 @@ func main() {
 				continue
 			}
 			op.append(texts[1], texts[2])
+		} else if texts[0] == "delete" {
+			if len(texts) < 2 {
+				fmt.Println("need key")
+				continue
+			}
+			op.client.Delete(texts[1])
+		} else if texts[0] == "scan" {
+			// scan [prefix [limit]]
+			prefix, limit := "", 0
+			if len(texts) > 1 {
+				prefix = texts[1]
+			}
+			if len(texts) > 2 {
+				var err error
+				if limit, err = strconv.Atoi(texts[2]); err != nil {
+					fmt.Println("invalid limit")
+					continue
+				}
+			}
+			for _, kv := range op.client.Scan(prefix, limit) {
+				fmt.Println(kv.Key, kv.Value)
+			}
 		} else if texts[0] == "write" {
 			if len(texts) < 2 {
 				fmt.Println("need value")
 				continue
 			}
 			op.writeToFile(texts[1])
+		} else if texts[0] == "hset" {
+			if len(texts) < 4 {
+				fmt.Println("need value")
+				continue
+			}
+			op.client.HSet(texts[1], texts[2], texts[3])
+		} else if texts[0] == "hget" {
+			if len(texts) < 3 {
+				fmt.Println("need field")
+				continue
+			}
+			fmt.Println(op.client.HGet(texts[1], texts[2]))
+		} else if texts[0] == "hdel" {
+			if len(texts) < 3 {
+				fmt.Println("need field")
+				continue
+			}
+			op.client.HDel(texts[1], texts[2])
+		} else if texts[0] == "lpush" {
+			if len(texts) < 3 {
+				fmt.Println("need value")
+				continue
+			}
+			op.client.LPush(texts[1], texts[2:]...)
+		} else if texts[0] == "rpop" {
+			if len(texts) < 2 {
+				fmt.Println("need key")
+				continue
+			}
+			fmt.Println(op.client.RPop(texts[1]))
+		} else if texts[0] == "lrange" {
+			if len(texts) < 4 {
+				fmt.Println("need start and stop")
+				continue
+			}
+			start, err1 := strconv.Atoi(texts[2])
+			stop, err2 := strconv.Atoi(texts[3])
+			if err1 != nil || err2 != nil {
+				fmt.Println("invalid range")
+				continue
+			}
+			fmt.Println(op.client.LRange(texts[1], start, stop))
+		} else if texts[0] == "sadd" {
+			if len(texts) < 3 {
+				fmt.Println("need member")
+				continue
+			}
+			op.client.SAdd(texts[1], texts[2:]...)
+		} else if texts[0] == "srem" {
+			if len(texts) < 3 {
+				fmt.Println("need member")
+				continue
+			}
+			op.client.SRem(texts[1], texts[2:]...)
+		} else if texts[0] == "smembers" {
+			if len(texts) < 2 {
+				fmt.Println("need key")
+				continue
+			}
+			fmt.Println(op.client.SMembers(texts[1]))
+		} else if texts[0] == "login" {
+			if len(texts) < 3 {
+				fmt.Println("need user and secret")
+				continue
+			}
+			op.client.Login(texts[1], texts[2])
+		} else if texts[0] == "adduser" {
+			if len(texts) < 3 {
+				fmt.Println("need user and secret")
+				continue
+			}
+			report(op.client.AddUser(texts[1], texts[2]))
+		} else if texts[0] == "deluser" {
+			if len(texts) < 2 {
+				fmt.Println("need user")
+				continue
+			}
+			report(op.client.DeleteUser(texts[1]))
+		} else if texts[0] == "grant" {
+			if len(texts) < 3 {
+				fmt.Println("need user and role")
+				continue
+			}
+			report(op.client.GrantRole(texts[1], texts[2]))
+		} else if texts[0] == "revoke" {
+			if len(texts) < 3 {
+				fmt.Println("need user and role")
+				continue
+			}
+			report(op.client.RevokeRole(texts[1], texts[2]))
+		} else if texts[0] == "permit" {
+			// the empty prefix, i.e. all keys, is written as "*".
+			if len(texts) < 4 {
+				fmt.Println("need role, prefix and permission")
+				continue
+			}
+			prefix := texts[2]
+			if prefix == "*" {
+				prefix = ""
+			}
+			report(op.client.SetPermission(texts[1], prefix, texts[3]))
+		} else if op.ctrler != nil && texts[0] == "join" {
+			if len(texts) < 3 {
+				fmt.Println("need gid and servers")
+				continue
+			}
+			gid, err := strconv.Atoi(texts[1])
+			if err != nil {
+				fmt.Println("invalid gid")
+				continue
+			}
+			op.ctrler.Join(map[int][]string{gid: texts[2:]})
+		} else if op.ctrler != nil && texts[0] == "leave" {
+			if len(texts) < 2 {
+				fmt.Println("need gid")
+				continue
+			}
+			gid, err := strconv.Atoi(texts[1])
+			if err != nil {
+				fmt.Println("invalid gid")
+				continue
+			}
+			op.ctrler.Leave([]int{gid})
+		} else if op.ctrler != nil && texts[0] == "move" {
+			if len(texts) < 3 {
+				fmt.Println("need shard and gid")
+				continue
+			}
+			shard, err1 := strconv.Atoi(texts[1])
+			gid, err2 := strconv.Atoi(texts[2])
+			if err1 != nil || err2 != nil {
+				fmt.Println("invalid shard or gid")
+				continue
+			}
+			op.ctrler.Move(shard, gid)
+		} else if op.ctrler != nil && texts[0] == "split" {
+			if len(texts) < 3 {
+				fmt.Println("need shard and key")
+				continue
+			}
+			shard, err := strconv.Atoi(texts[1])
+			if err != nil {
+				fmt.Println("invalid shard")
+				continue
+			}
+			op.ctrler.Split(shard, texts[2])
+		} else if op.ctrler != nil && texts[0] == "query" {
+			fmt.Println(op.ctrler.Query(-1))
 		} else {
 			fmt.Println("unknown operation")
 		}
@@ -79,6 +273,13 @@ func main() {
 
 type Operator struct {
 	client *kvraft.Clerk
+	ctrler *shardctrler.Clerk
+}
+
+func report(err error) {
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 func (op *Operator) append(key string, value string) {
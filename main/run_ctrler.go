@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"DDB/client"
+	"DDB/raft"
+	"DDB/secure"
+	"DDB/shardctrler"
+)
+
+func GetLocalIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, address := range addrs {
+		// check the address type and if it is not a loopback the display it
+		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return ""
+}
+
+func main() {
+	caFile := flag.String("ca", "", "CA certificate, to enable mutual TLS")
+	certFile := flag.String("cert", "", "certificate of this process, for both serving and calling")
+	keyFile := flag.String("key", "", "key of the certificate")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		log.Fatal("Need port")
+		return
+	}
+	if *caFile != "" {
+		if err := secure.Setup(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	clients := []*client.Client{}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Welcome to DDB shard controller")
+	fmt.Println("Type 'a IP port' to add existing controllers, use 'done' to finish adding controllers.")
+
+	for {
+		fmt.Println("-> ")
+		text, _ := reader.ReadString('\n')
+		text = strings.Replace(text, "\n", "", -1)
+		texts := strings.Split(text, " ")
+		if texts[0] == "done" {
+			fmt.Println("done")
+			break
+		}
+		if texts[0] == "a" {
+			if len(texts) < 2 {
+				fmt.Println("Need IP")
+				continue
+			}
+			if len(texts) < 3 {
+				fmt.Println("Need port")
+				continue
+			}
+			ip := texts[1]
+			port := texts[2]
+			cl := client.MakeClient(ip, port)
+			clients = append(clients, cl)
+		} else {
+			fmt.Println("Invalid command")
+		}
+	}
+	me := len(clients)
+	localIP := GetLocalIP()
+	fmt.Println("Local IP:", localIP)
+	cl := client.MakeClient(localIP, args[0])
+	clients = append(clients, cl)
+	persister := raft.MakePersister()
+	sc := shardctrler.StartServer(clients, me, persister, args[0])
+	log.Println("ok")
+	for !sc.Killed() {
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"DDB/client"
+	"DDB/multiraft"
+	"DDB/raft"
+	"DDB/secure"
+)
+
+func GetLocalIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, address := range addrs {
+		// check the address type and if it is not a loopback the display it
+		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return ""
+}
+
+func main() {
+	caFile := flag.String("ca", "", "CA certificate, to enable mutual TLS")
+	certFile := flag.String("cert", "", "certificate of this process, for both serving and calling")
+	keyFile := flag.String("key", "", "key of the certificate")
+	dir := flag.String("dir", "", "directory to keep the state of the groups in, so that they survive a restart; empty for memory only")
+	config := raft.DefaultConfig
+	flag.DurationVar(&config.HeartbeatInterval, "heartbeat", config.HeartbeatInterval, "interval between the heartbeats of the leaders, and between the ticks of the host")
+	flag.DurationVar(&config.ElectionTimeoutMin, "election-min", config.ElectionTimeoutMin, "min election timeout")
+	flag.DurationVar(&config.ElectionTimeoutMax, "election-max", config.ElectionTimeoutMax, "max election timeout")
+	flag.IntVar(&config.MaxRaftState, "max-raft-state", config.MaxRaftState, "size of the raft state at which to snapshot, -1 for never")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		log.Fatal("Need port")
+		return
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *caFile != "" {
+		if err := secure.Setup(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the "ip:port" addresses of the shard controllers.
+	ctrlers := []*client.Client{}
+	for _, address := range args[1:] {
+		ctrlers = append(ctrlers, client.MakeClientFromAddress(address))
+	}
+	localIP := GetLocalIP()
+	fmt.Println("Local IP:", localIP)
+	local := client.MakeClient(localIP, args[0])
+	host := multiraft.MakeHost(args[0], ctrlers, config, *dir)
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Welcome to DDB multi-raft host")
+	fmt.Println("Type 'g gid IP:port...' to host a group, listing all its members including this host.")
+	for !host.Killed() {
+		fmt.Println("-> ")
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		text = strings.Replace(text, "\n", "", -1)
+		texts := strings.Split(text, " ")
+		if texts[0] == "g" {
+			if len(texts) < 3 {
+				fmt.Println("Need gid and servers")
+				continue
+			}
+			gid, err := strconv.Atoi(texts[1])
+			if err != nil || gid == 0 {
+				fmt.Println("Invalid gid")
+				continue
+			}
+			servers := []*client.Client{}
+			me := -1
+			for i, address := range texts[2:] {
+				cl := client.MakeClientFromAddress(address)
+				if cl.Address() == local.Address() {
+					me = i
+				}
+				servers = append(servers, cl)
+			}
+			if me == -1 {
+				fmt.Println("This host must be one of the servers")
+				continue
+			}
+			if err := host.AddGroup(gid, servers, me); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			log.Println("ok")
+		} else {
+			fmt.Println("Invalid command")
+		}
+	}
+	for !host.Killed() {
+		time.Sleep(1 * time.Second)
+	}
+}
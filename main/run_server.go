@@ -2,16 +2,22 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"DDB/api"
 	"DDB/client"
 	"DDB/kvraft"
 	"DDB/raft"
+	"DDB/secure"
+	"DDB/transport"
 )
 
 func GetLocalIP() string {
@@ -31,10 +37,50 @@ func GetLocalIP() string {
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	caFile := flag.String("ca", "", "CA certificate, to enable mutual TLS")
+	certFile := flag.String("cert", "", "certificate of this process, for both serving and calling")
+	keyFile := flag.String("key", "", "key of the certificate")
+	grpcPort := flag.String("grpc", "", "port to serve the gRPC API on")
+	httpPort := flag.String("http", "", "port to serve the REST API on")
+	respPort := flag.String("resp", "", "port to serve the Redis protocol on")
+	dir := flag.String("dir", "", "directory to keep the raft state and snapshots in, so that they survive a restart; empty for memory only")
+	config := raft.DefaultConfig
+	flag.DurationVar(&config.HeartbeatInterval, "heartbeat", config.HeartbeatInterval, "interval between the heartbeats of the leader")
+	flag.DurationVar(&config.ElectionTimeoutMin, "election-min", config.ElectionTimeoutMin, "min election timeout")
+	flag.DurationVar(&config.ElectionTimeoutMax, "election-max", config.ElectionTimeoutMax, "max election timeout")
+	flag.IntVar(&config.MaxInflight, "max-inflight", config.MaxInflight, "max number of AppendEntries in flight to a follower")
+	flag.IntVar(&config.MaxMsgBytes, "max-msg-bytes", config.MaxMsgBytes, "max size of the entries of an AppendEntries")
+	flag.IntVar(&config.MaxMsgEntries, "max-msg-entries", config.MaxMsgEntries, "max number of entries of an AppendEntries, 0 for no limit")
+	flag.IntVar(&config.MaxRaftState, "max-raft-state", config.MaxRaftState, "size of the raft state at which to snapshot, -1 for never")
+	flag.IntVar(&config.Priority, "priority", config.Priority, "priority to be leader, e.g. higher in the primary data center")
+	flag.BoolVar(&config.Witness, "witness", config.Witness, "run a witness, which votes but keeps no data and serves no clients")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
 		log.Fatal("Need port")
 		return
 	}
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *caFile != "" {
+		if err := secure.Setup(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// optional: the replica group id followed by the "ip:port" addresses of the shard controllers.
+	gid := 0
+	ctrlers := []*client.Client{}
+	if len(args) > 1 {
+		var err error
+		if gid, err = strconv.Atoi(args[1]); err != nil {
+			log.Fatal("Invalid gid")
+			return
+		}
+		for _, address := range args[2:] {
+			ctrlers = append(ctrlers, client.MakeClientFromAddress(address))
+		}
+	}
 	clients := []*client.Client{}
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Welcome to DDB")
@@ -69,10 +115,36 @@ func main() {
 	me := len(clients)
 	localIP := GetLocalIP()
 	fmt.Println("Local IP:", localIP)
-	cl := client.MakeClient(localIP, os.Args[1])
+	cl := client.MakeClient(localIP, args[0])
 	clients = append(clients, cl)
 	persister := raft.MakePersister()
-	kv := kvraft.StartKVServer(clients, me, persister, 0, os.Args[1])
+	if *dir != "" {
+		var err error
+		if persister, err = raft.MakeFilePersister(filepath.Join(*dir, "raft")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.Witness {
+		kvraft.StartWitness(transport.TCP, clients, me, persister, config, args[0])
+		log.Println("ok")
+		select {}
+	}
+	kv := kvraft.StartShardKVServerOn(transport.TCP, clients, me, persister, config, args[0], gid, ctrlers)
+	if *grpcPort != "" {
+		if err := api.Serve(kv, *grpcPort); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *httpPort != "" {
+		if err := api.ServeREST(kv, *httpPort); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *respPort != "" {
+		if err := api.ServeRESP(kv, *respPort); err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Println("ok")
 	for !kv.Killed() {
 		time.Sleep(1 * time.Second)
@@ -0,0 +1,207 @@
+package multiraft
+
+//
+// a multi-raft host runs many raft groups, along with their KV servers, in one process.
+// all the groups share one listener and RPC server, to which the RPCs are routed by
+// the group id they carry, one ticker driving their timers, and one storage engine.
+// the heartbeats of all the groups led by the host are coalesced into one RPC per
+// peer host and tick.
+//
+
+import (
+	"context"
+	"log"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"DDB/client"
+	"DDB/kvraft"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+type Host struct {
+	mu      sync.Mutex
+	groups  map[int]*kvraft.KVServer // gid -> server
+	adding  map[int]bool             // the groups being started by AddGroup.
+	storage *raft.Storage
+	ctrlers []*client.Client
+	config  raft.Config // the config of every group; the host ticks once per heartbeat interval.
+	dead    int32       // set by Kill()
+
+	transport transport.Transport
+	port      string // the address served on.
+}
+
+// MakeHost starts a host serving on port, which keeps the state of its groups in files of
+// dir, or in memory only if dir is empty.
+func MakeHost(port string, ctrlers []*client.Client, config raft.Config, dir string) *Host {
+	return MakeHostOn(transport.TCP, port, ctrlers, config, dir)
+}
+
+// MakeHostOn is MakeHost over the transport t, on which the host serves at address.
+func MakeHostOn(t transport.Transport, address string, ctrlers []*client.Client, config raft.Config, dir string) *Host {
+	h := new(Host)
+	h.groups = make(map[int]*kvraft.KVServer)
+	h.adding = make(map[int]bool)
+	h.storage = raft.MakeStorage(dir)
+	h.ctrlers = ctrlers
+	h.config = config
+	h.transport = t
+	h.port = address
+
+	h.server()
+	go h.ticker()
+
+	return h
+}
+
+// AddGroup starts hosting replica gid, of which this host is servers[me], from the state
+// the storage holds for it, if any.
+func (h *Host) AddGroup(gid int, servers []*client.Client, me int) error {
+	h.mu.Lock()
+	if _, ok := h.groups[gid]; ok || h.adding[gid] {
+		h.mu.Unlock()
+		return nil
+	}
+	h.adding[gid] = true
+	h.mu.Unlock()
+
+	// the group is started without h.mu, since its raft calls the peers as it starts, and
+	// the other groups mustn't wait for them.
+	persister, err := h.storage.Persister(gid)
+	var kv *kvraft.KVServer
+	if err == nil {
+		kv = kvraft.StartHostedKVServer(h.transport, servers, me, persister, h.config, gid, h.ctrlers)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.adding, gid)
+	if err != nil {
+		return err
+	}
+	h.groups[gid] = kv
+	return nil
+}
+
+func (h *Host) group(gid int) *kvraft.KVServer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.groups[gid]
+}
+
+func (h *Host) rafts() []*raft.Raft {
+	rafts := []*raft.Raft{}
+	for _, kv := range h.servers() {
+		rafts = append(rafts, kv.Raft())
+	}
+	return rafts
+}
+
+// ticker drives the timers of all the groups, and sends their heartbeats coalesced by peer host.
+func (h *Host) ticker() {
+	for !h.Killed() {
+		time.Sleep(h.config.HeartbeatInterval)
+
+		beats := make(map[string][]raft.Heartbeat) // peer host address -> heartbeats
+		for _, rf := range h.rafts() {
+			for _, beat := range rf.Tick() {
+				address := beat.To.Address()
+				beats[address] = append(beats[address], beat)
+			}
+		}
+		for _, batch := range beats {
+			go h.sendHeartbeats(batch)
+		}
+	}
+}
+
+func (h *Host) sendHeartbeats(batch []raft.Heartbeat) {
+	args := raft.HeartbeatArgs{}
+	for _, beat := range batch {
+		args.Beats = append(args.Beats, beat.Args)
+	}
+	reply := raft.HeartbeatReply{}
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.RPCTimeout())
+	defer cancel()
+	if !batch[0].To.CallContext(ctx, "Raft.Heartbeat", &args, &reply) {
+		return
+	}
+	for i, beat := range batch {
+		if i < len(reply.Found) && reply.Found[i] {
+			if kv := h.group(beat.Args.Group); kv != nil {
+				kv.Raft().HandleHeartbeat(beat.Peer, &args.Beats[i], &reply.Replies[i])
+			}
+		}
+	}
+}
+
+func (h *Host) server() {
+	peers := rpc.NewServer()
+	if peers.RegisterName("Raft", &raftRouter{h}) != nil {
+		log.Fatal("error")
+	}
+	if peers.RegisterName("KVServer", &kvRouter{h}) != nil {
+		log.Fatal("error")
+	}
+	groups := rpc.NewServer()
+	if groups.RegisterName("KVServer", &groupRouter{clientRouter{h}}) != nil {
+		log.Fatal("error")
+	}
+	clients := rpc.NewServer()
+	if clients.RegisterName("KVServer", &clientRouter{h}) != nil {
+		log.Fatal("error")
+	}
+	e := h.transport.Serve(h.port, clients,
+		transport.Tier{Server: peers, Members: h.peers},
+		transport.Tier{Server: groups, Members: h.others})
+	if e != nil {
+		log.Fatal(e)
+	}
+}
+
+func (h *Host) servers() []*kvraft.KVServer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	groups := make([]*kvraft.KVServer, 0, len(h.groups))
+	for _, kv := range h.groups {
+		groups = append(groups, kv)
+	}
+	return groups
+}
+
+// peers returns the peers of any of the hosted groups.
+// note: a peer of one group may thus call the raft RPCs of the others hosted along with it.
+func (h *Host) peers() []string {
+	peers := []string{}
+	for _, kv := range h.servers() {
+		peers = append(peers, kv.Peers()...)
+	}
+	return peers
+}
+
+// others returns the servers of the other groups known to any of the hosted groups.
+func (h *Host) others() []string {
+	others := []string{}
+	for _, kv := range h.servers() {
+		others = append(others, kv.Others()...)
+	}
+	return others
+}
+
+func (h *Host) Kill() {
+	atomic.StoreInt32(&h.dead, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, kv := range h.groups {
+		kv.Kill()
+	}
+}
+
+func (h *Host) Killed() bool {
+	z := atomic.LoadInt32(&h.dead)
+	return z == 1
+}
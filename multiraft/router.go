@@ -0,0 +1,142 @@
+package multiraft
+
+import (
+	"DDB/kvraft"
+	"DDB/raft"
+)
+
+// raftRouter serves the "Raft" RPCs of a host, and hands each of them to the raft of its group.
+// RPCs for a group that isn't hosted are dropped, as if the peer were down.
+type raftRouter struct {
+	h *Host
+}
+
+func (r *raftRouter) raft(gid int) *raft.Raft {
+	if kv := r.h.group(gid); kv != nil {
+		return kv.Raft()
+	}
+	return nil
+}
+
+func (r *raftRouter) AppendEntries(args *raft.AppendEntriesArgs, reply *raft.AppendEntriesReply) error {
+	if rf := r.raft(args.Group); rf != nil {
+		return rf.AppendEntries(args, reply)
+	}
+	return nil
+}
+
+func (r *raftRouter) RequestVote(args *raft.RequestVoteArgs, reply *raft.RequestVoteReply) error {
+	if rf := r.raft(args.Group); rf != nil {
+		return rf.RequestVote(args, reply)
+	}
+	return nil
+}
+
+func (r *raftRouter) InstallSnapshot(args *raft.InstallSnapshotArgs, reply *raft.InstallSnapshotReply) error {
+	if rf := r.raft(args.Group); rf != nil {
+		return rf.InstallSnapshot(args, reply)
+	}
+	return nil
+}
+
+func (r *raftRouter) TimeoutNow(args *raft.TimeoutNowArgs, reply *raft.TimeoutNowReply) error {
+	if rf := r.raft(args.Group); rf != nil {
+		return rf.TimeoutNow(args, reply)
+	}
+	return nil
+}
+
+func (r *raftRouter) Init(args *raft.InitArgs, reply *raft.InitReply) error {
+	if rf := r.raft(args.Group); rf != nil {
+		return rf.Init(args, reply)
+	}
+	return nil
+}
+
+// Heartbeat unpacks the heartbeats coalesced by a peer host.
+func (r *raftRouter) Heartbeat(args *raft.HeartbeatArgs, reply *raft.HeartbeatReply) error {
+	reply.Replies = make([]raft.AppendEntriesReply, len(args.Beats))
+	reply.Found = make([]bool, len(args.Beats))
+	for i := range args.Beats {
+		if rf := r.raft(args.Beats[i].Group); rf != nil {
+			rf.AppendEntries(&args.Beats[i], &reply.Replies[i])
+			reply.Found[i] = true
+		}
+	}
+	return nil
+}
+
+// kvRouter serves the "KVServer" RPCs of a host to the cluster members.
+type kvRouter struct {
+	h *Host
+}
+
+func (r *kvRouter) Get(args *kvraft.GetArgs, reply *kvraft.GetReply) error {
+	if kv := r.h.group(args.Gid); kv != nil {
+		return kv.Get(args, reply)
+	}
+	reply.Err = kvraft.ErrWrongGroup
+	return nil
+}
+
+func (r *kvRouter) PutAppend(args *kvraft.PutAppendArgs, reply *kvraft.PutAppendReply) error {
+	if kv := r.h.group(args.Gid); kv != nil {
+		return kv.PutAppend(args, reply)
+	}
+	reply.Err = kvraft.ErrWrongGroup
+	return nil
+}
+
+func (r *kvRouter) Command(args *kvraft.CommandArgs, reply *kvraft.CommandReply) error {
+	if kv := r.h.group(args.Gid); kv != nil {
+		return kv.Command(args, reply)
+	}
+	reply.Err = kvraft.ErrWrongGroup
+	return nil
+}
+
+func (r *kvRouter) PullShard(args *kvraft.PullShardArgs, reply *kvraft.PullShardReply) error {
+	if kv := r.h.group(args.Gid); kv != nil {
+		return kv.PullShard(args, reply)
+	}
+	reply.Err = kvraft.ErrWrongGroup
+	return nil
+}
+
+func (r *kvRouter) DeleteShard(args *kvraft.DeleteShardArgs, reply *kvraft.DeleteShardReply) error {
+	if kv := r.h.group(args.Gid); kv != nil {
+		return kv.DeleteShard(args, reply)
+	}
+	reply.Err = kvraft.ErrWrongGroup
+	return nil
+}
+
+// groupRouter serves the "KVServer" RPCs of a host that the servers of other groups may call.
+type groupRouter struct {
+	clientRouter
+}
+
+func (r *groupRouter) PullShard(args *kvraft.PullShardArgs, reply *kvraft.PullShardReply) error {
+	return (&kvRouter{r.h}).PullShard(args, reply)
+}
+
+func (r *groupRouter) DeleteShard(args *kvraft.DeleteShardArgs, reply *kvraft.DeleteShardReply) error {
+	return (&kvRouter{r.h}).DeleteShard(args, reply)
+}
+
+// clientRouter serves the "KVServer" RPCs of a host that clients may call.
+type clientRouter struct {
+	h *Host
+}
+
+func (r *clientRouter) Get(args *kvraft.GetArgs, reply *kvraft.GetReply) error {
+	return (&kvRouter{r.h}).Get(args, reply)
+}
+
+func (r *clientRouter) PutAppend(args *kvraft.PutAppendArgs, reply *kvraft.PutAppendReply) error {
+	return (&kvRouter{r.h}).PutAppend(args, reply)
+}
+
+func (r *clientRouter) Command(args *kvraft.CommandArgs, reply *kvraft.CommandReply) error {
+	return (&kvRouter{r.h}).Command(args, reply)
+}
@@ -1,13 +1,21 @@
 package raft
 
+import (
+	"sort"
+	"time"
+)
+
 type AppendEntriesArgs struct {
 	// Your data here (2A, 2B).
+	Group        int // the raft group, for the rafts of a multi-raft host.
 	Term         int
 	LeaderId     int
+	Leader       string // the address of the leader, since peers may be ordered differently on each server.
 	PrevLogIndex int
 	PrevLogTerm  int
 	Entries      []Entry
 	LeaderCommit int
+	Pin          bool // set for a witness to pin the entries, see witness.go.
 }
 
 // example RequestVote RPC reply structure.
@@ -20,6 +28,8 @@ type AppendEntriesReply struct {
 	XTerm    int
 	XIndex   int
 	XLen     int
+	Priority int  // the priority of the follower, for the leader to hand leadership over.
+	Witness  bool // set if the follower is a witness, which needs no commands.
 }
 
 // example RequestVote RPC handler.
@@ -29,6 +39,11 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 	defer rf.mu.Unlock()
 	reply.Term = rf.currentTerm
 	reply.Success = false
+	reply.Priority = rf.config.Priority
+	reply.Witness = rf.config.Witness
+	if rf.config.Witness {
+		stripped(args.Entries)
+	}
 	if args.Term < rf.currentTerm {
 		return nil
 	}
@@ -36,6 +51,7 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 		rf.becomeFollower(args.Term)
 	}
 	rf.resetElection()
+	rf.leaderId = rf.indexOf(args.Leader)
 	if rf.state == Candidate {
 		rf.state = Follower
 	}
@@ -71,6 +87,9 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 		if entry.Index <= rf.log.lastEntry().Index && entry.Index > rf.log.FirstIndex &&
 			rf.log.at(entry.Index).Term != entry.Term {
 			rf.log.Entries = rf.log.sliceFromStart(entry.Index)
+			if rf.config.Witness {
+				rf.unpin(entry.Index - 1)
+			}
 			rf.persist()
 		}
 		if entry.Index > rf.log.lastEntry().Index {
@@ -79,8 +98,13 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 			break
 		}
 	}
-	if args.LeaderCommit > rf.commitIndex {
-		rf.commitIndex = min(args.LeaderCommit, rf.log.lastEntry().Index)
+	if rf.config.Witness && args.Pin {
+		rf.pin(args.PrevLogIndex + len(args.Entries))
+	}
+	// only the entries up to the last one sent are known to match the leader's: those after
+	// it may be left over from an older term, e.g. on a heartbeat.
+	if commit := min(args.LeaderCommit, args.PrevLogIndex+len(args.Entries)); commit > rf.commitIndex {
+		rf.commitIndex = commit
 		rf.apply()
 	}
 	reply.Success = true
@@ -92,22 +116,63 @@ func (rf *Raft) sendAppendEntries(
 	args *AppendEntriesArgs,
 	reply *AppendEntriesReply,
 ) {
-	ok := rf.peers[server].Call("Raft.AppendEntries", args, reply)
+	ok := rf.call(server, "Raft.AppendEntries", args, reply)
+	if ok {
+		rf.HandleHeartbeat(server, args, reply)
+		return
+	}
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
-	if !ok {
-		return
+	if args.Term == rf.currentTerm && rf.state == Leader && len(args.Entries) > 0 &&
+		rf.progress[server].state == Replicate {
+		// the entries may be lost, so that the next ones would be rejected: probe again.
+		rf.progress[server].becomeProbe()
+		rf.nextIndex[server] = rf.matchIndex[server] + 1
 	}
+}
+
+// HandleHeartbeat processes the reply of an AppendEntries RPC sent to server.
+// it's also called by a multi-raft host for the heartbeats it has coalesced.
+func (rf *Raft) HandleHeartbeat(
+	server int,
+	args *AppendEntriesArgs,
+	reply *AppendEntriesReply,
+) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
 	if args.Term == rf.currentTerm {
 		if reply.Term > rf.currentTerm {
 			rf.becomeFollower(reply.Term)
+			return
 		}
+		if rf.state != Leader {
+			return
+		}
+		rf.priorities[server] = reply.Priority
+		rf.witnesses[server] = reply.Witness
+		pr := rf.progress[server]
+		pr.replied = time.Now()
 		if reply.Success {
 			match := args.PrevLogIndex + len(args.Entries)
 			next := match + 1
 			rf.nextIndex[server] = max(rf.nextIndex[server], next)
 			rf.matchIndex[server] = max(rf.matchIndex[server], match)
-		} else if reply.Conflict {
+			pr.ack(rf.matchIndex[server])
+			if args.Pin {
+				pr.pinned = max(pr.pinned, match)
+			}
+			if pr.state == Probe {
+				pr.becomeReplicate()
+				rf.nextIndex[server] = rf.matchIndex[server] + 1
+			}
+			return
+		}
+		if args.PrevLogIndex < rf.matchIndex[server] {
+			// a stale reject, of an AppendEntries sent before the follower caught up.
+			return
+		}
+		pr.becomeProbe()
+		if reply.Conflict {
 			if reply.XTerm == -1 {
 				rf.nextIndex[server] = reply.XLen
 			} else {
@@ -121,70 +186,68 @@ func (rf *Raft) sendAppendEntries(
 		} else if !reply.Success && rf.nextIndex[server] > 1 {
 			rf.nextIndex[server]--
 		}
+		rf.nextIndex[server] = max(rf.nextIndex[server], rf.matchIndex[server]+1)
 	}
 }
 
 func (rf *Raft) leaderAppendEntries() {
+	rf.leaderHeartbeats(false)
+}
+
+// leaderHeartbeats sends AppendEntries to all peers. if coalesced is set, the
+// heartbeats without entries are returned rather than sent, for the host to send them.
+func (rf *Raft) leaderHeartbeats(coalesced bool) []Heartbeat {
+	beats := []Heartbeat{}
+	rf.persistBatch()
 	rf.resetElection()
-	lastLog := rf.log.lastEntry()
 	for peer := range rf.peers {
 		if rf.me == peer {
-			rf.resetElection()
 			continue
 		}
-		nextIndex := rf.nextIndex[peer]
-		if lastLog.Index+1 < nextIndex {
-			nextIndex = lastLog.Index
-		}
-		if nextIndex-1 < rf.log.FirstIndex {
-			go rf.sendInstallSnapshot(peer)
-			continue
-		}
-		prevLog := rf.log.at(nextIndex - 1)
-		args := AppendEntriesArgs{}
-		reply := AppendEntriesReply{}
-		args.Term = rf.currentTerm
-		args.LeaderId = rf.me
-		args.LeaderCommit = rf.commitIndex
-		args.PrevLogTerm = prevLog.Term
-		args.PrevLogIndex = nextIndex - 1
-		args.Entries = rf.log.sliceToEnd(nextIndex)
-		go rf.sendAppendEntries(peer, &args, &reply)
+		beats = rf.replicate(peer, coalesced, beats)
 	}
 	rf.checkLeaderCommit()
+	rf.checkTransfer()
+	return beats
 }
 
+// checkLeaderCommit commits up to the highest index stored on a majority of the replicas,
+// i.e. the median of their match indices, the leader's own included once it's saved. the
+// witnesses don't count, except to break a tie among the replicas, see witness.go. as in
+// figure 8 of the raft paper, only an entry of the current term is committed by counting
+// replicas; the entries before it are committed along with it.
 func (rf *Raft) checkLeaderCommit() {
 	if rf.state != Leader {
 		return
 	}
-	count := make(map[int]int)
-	for peer := range rf.peers {
-		N := rf.matchIndex[peer]
-		if N > rf.commitIndex {
-			count[N] += 1
+	replicas := []int{}
+	pinned := 0
+	for peer, match := range rf.matchIndex {
+		if rf.witnesses[peer] {
+			pinned = max(pinned, rf.progress[peer].pinned)
+		} else {
+			replicas = append(replicas, match)
 		}
 	}
-	values := 0
-	updated := false
-	var smallestBiggerKey int
-	init := false
-	for key, value := range count {
-		values += value
-		if !init {
-			init = true
-			smallestBiggerKey = key
-		}
-		if key < smallestBiggerKey {
-			smallestBiggerKey = key
-		}
-		if (value+1)*2 > len(rf.peers) && key > rf.commitIndex {
-			updated = true
-			rf.commitIndex = key
-			rf.apply()
-		}
+	N := quorumIndex(replicas)
+	if len(replicas)%2 == 0 {
+		N = max(N, min(indexOn(replicas, len(replicas)/2), pinned))
 	}
-	if !updated && (values+1)*2 > len(rf.peers) {
-		rf.commitIndex = smallestBiggerKey
+	if N <= rf.commitIndex || N < rf.log.FirstIndex || rf.log.at(N).Term != rf.currentTerm {
+		return
 	}
+	rf.commitIndex = N
+	rf.apply()
+}
+
+// quorumIndex returns the highest index that a majority of match is at or above.
+func quorumIndex(match []int) int {
+	return indexOn(match, len(match)/2+1)
+}
+
+// indexOn returns the highest index that n of match are at or above.
+func indexOn(match []int, n int) int {
+	sorted := append([]int(nil), match...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	return sorted[n-1]
 }
@@ -0,0 +1,151 @@
+package raft
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuorumIndex(t *testing.T) {
+	tests := []struct {
+		match []int
+		want  int
+	}{
+		{[]int{4}, 4},
+		{[]int{5, 3, 4}, 4},
+		{[]int{5, 3, 4, 1, 2}, 3},
+		// with an even number of peers, a majority is more than half of them.
+		{[]int{5, 3}, 3},
+		{[]int{5, 3, 4, 1}, 3},
+		{[]int{6, 6, 2, 2}, 2},
+		// the leader's own entries count only once saved: a leader (first) that has yet to
+		// save its log up to 9 holds 7 for the quorum.
+		{[]int{7, 9, 9}, 9},
+		{[]int{7, 9, 3}, 7},
+		{[]int{7, 9, 9, 3}, 7},
+		{[]int{7, 9, 9, 9, 3}, 9},
+	}
+	for _, test := range tests {
+		match := append([]int(nil), test.match...)
+		if got := quorumIndex(match); got != test.want {
+			t.Errorf("quorumIndex(%v) = %d, want %d", test.match, got, test.want)
+		}
+		for i := range match {
+			if match[i] != test.match[i] {
+				t.Fatalf("quorumIndex(%v) changed its argument to %v", test.match, match)
+			}
+		}
+	}
+}
+
+// leader makes the leader of term of a group of n, with a log of entries of the given terms
+// after the dummy entry at index 0.
+func leader(term int, n int, terms ...int) *Raft {
+	rf := &Raft{state: Leader, currentTerm: term, matchIndex: make([]int, n), witnesses: make([]bool, n)}
+	rf.applyCond = sync.NewCond(&rf.mu)
+	for i := 0; i < n; i++ {
+		rf.progress = append(rf.progress, &progress{})
+	}
+	rf.log.appendLog(Entry{})
+	for i, t := range terms {
+		rf.log.appendLog(Entry{Term: t, Index: i + 1})
+	}
+	return rf
+}
+
+// Figure 8 of the raft paper: the leader of term 4 finds an entry of term 2 at index 2 on a
+// majority. it mustn't commit it by counting replicas, since a server that missed it could
+// still be elected with a log of term 3 and overwrite it, until an entry of term 4 is
+// committed on top of it.
+func TestFigure8(t *testing.T) {
+	rf := leader(4, 5, 1, 2)
+
+	copy(rf.matchIndex, []int{2, 2, 2, 1, 0})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 0 {
+		t.Fatalf("an entry of an old term was committed at %d by counting replicas", rf.commitIndex)
+	}
+
+	rf.log.appendLog(Entry{Term: 4, Index: 3})
+	copy(rf.matchIndex, []int{3, 3, 2, 2, 0})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 0 {
+		t.Fatalf("an entry of the current term on a minority was committed at %d", rf.commitIndex)
+	}
+
+	copy(rf.matchIndex, []int{3, 3, 3, 2, 0})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 3 {
+		t.Fatalf("commitIndex = %d once the entry of term 4 is on a majority, want 3", rf.commitIndex)
+	}
+}
+
+// with two replicas and a witness, the leader and the witness alone don't commit an entry,
+// or else the other replica couldn't take over without it. they do once the witness has
+// pinned it, the other replica being down.
+func TestWitnessBreaksTies(t *testing.T) {
+	rf := leader(2, 3, 2, 2)
+	rf.witnesses[2] = true
+
+	copy(rf.matchIndex, []int{2, 0, 2})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 0 {
+		t.Fatalf("an entry on the leader and the witness alone was committed at %d", rf.commitIndex)
+	}
+
+	rf.progress[2].pinned = 1
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 1 {
+		t.Fatalf("commitIndex = %d once the witness pinned 1, want 1", rf.commitIndex)
+	}
+
+	copy(rf.matchIndex, []int{2, 2, 2})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 2 {
+		t.Fatalf("commitIndex = %d once both replicas hold 2, want 2", rf.commitIndex)
+	}
+}
+
+func TestFollowerNeverCommitsByCounting(t *testing.T) {
+	rf := leader(2, 3, 2, 2)
+	rf.state = Follower
+	copy(rf.matchIndex, []int{2, 2, 2})
+	rf.checkLeaderCommit()
+	if rf.commitIndex != 0 {
+		t.Fatalf("a follower committed at %d", rf.commitIndex)
+	}
+}
+
+// follower makes a follower of term, with a log of entries of the given terms.
+func follower(term int, terms ...int) *Raft {
+	rf := leader(term, 0, terms...)
+	rf.state = Follower
+	rf.config = DefaultConfig
+	rf.persister = MakePersister()
+	rf.electionTimer = time.NewTimer(time.Hour)
+	rf.heartBeatTimer = time.NewTimer(time.Hour)
+	return rf
+}
+
+// a follower left with entries of a deposed leader mustn't commit them on a heartbeat of the
+// new one, whatever its commit index: only the entries up to those sent are known to match.
+func TestHeartbeatCommitsOnlyMatchedEntries(t *testing.T) {
+	rf := follower(2, 1, 2, 2)
+
+	args := AppendEntriesArgs{Term: 3, PrevLogIndex: 1, PrevLogTerm: 1, LeaderCommit: 3}
+	reply := AppendEntriesReply{}
+	rf.AppendEntries(&args, &reply)
+	if !reply.Success {
+		t.Fatalf("the heartbeat was rejected")
+	}
+	if rf.commitIndex != 1 {
+		t.Fatalf("commitIndex = %d after a heartbeat from 1, want 1", rf.commitIndex)
+	}
+
+	args = AppendEntriesArgs{Term: 3, PrevLogIndex: 1, PrevLogTerm: 1, Entries: []Entry{{Term: 3, Index: 2}}, LeaderCommit: 3}
+	reply = AppendEntriesReply{}
+	rf.AppendEntries(&args, &reply)
+	if rf.commitIndex != 2 || rf.log.at(2).Term != 3 {
+		t.Fatalf("commitIndex = %d with the entry at 2 of term %d, want 2 of term 3", rf.commitIndex, rf.log.at(2).Term)
+	}
+}
@@ -0,0 +1,67 @@
+package raft
+
+//
+// the leader batches the entries proposed by Start: they are appended to the log at once,
+// but persisted and sent to the followers together, by a single persist in the background
+// and a single round of AppendEntries, once batchDelay has passed since the first of them or maxBatchSize of
+// them are waiting. the heartbeats also carry out the current batch.
+//
+
+import "time"
+
+const (
+	batchDelay   = 1 * time.Millisecond // how long a proposal may wait for others to join its batch.
+	maxBatchSize = 64                   // the number of proposals that flushes a batch at once.
+)
+
+// batch adds the entry just appended by Start to the current batch. the caller holds rf.mu.
+func (rf *Raft) batch() {
+	rf.batched++
+	if rf.batched >= maxBatchSize {
+		rf.flush()
+	} else if rf.batched == 1 {
+		time.AfterFunc(batchDelay, func() {
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+			rf.flush()
+		})
+	}
+}
+
+// flush persists the current batch, and sends it to the followers while still the leader.
+func (rf *Raft) flush() {
+	if rf.batched == 0 || rf.killed() {
+		return
+	}
+	if rf.state == Leader {
+		rf.leaderAppendEntries()
+	} else {
+		rf.persistBatch()
+	}
+}
+
+// persistBatch persists the current batch, if any, as it's sent out. the leader does it in
+// parallel with the replication to the followers, as in §10.2.1 of the raft dissertation:
+// it counts itself toward the quorum of an entry only once the entry is saved.
+func (rf *Raft) persistBatch() {
+	if rf.batched == 0 {
+		return
+	}
+	rf.batched = 0
+	if rf.state != Leader {
+		rf.persist()
+		return
+	}
+	rf.persistSeq++
+	seq, state, snapshot := rf.persistSeq, rf.encodeState(), rf.snapshot.Data
+	term, last := rf.currentTerm, rf.log.lastEntry().Index
+	go func() {
+		rf.save(seq, state, snapshot)
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		if rf.currentTerm == term && rf.state == Leader {
+			rf.matchIndex[rf.me] = max(rf.matchIndex[rf.me], last)
+			rf.checkLeaderCommit()
+		}
+	}()
+}
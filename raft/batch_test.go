@@ -0,0 +1,44 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+// the leader sends a batch out while it's still saving it, but counts itself toward the
+// quorum of its entries only once they're saved.
+func TestLeaderSelfMatchLagsSave(t *testing.T) {
+	rf, _ := connected(t, DefaultConfig, 1, 3)
+	rf.saveMu.Lock()
+	index, _, ok := rf.Start("x")
+	if !ok {
+		t.Fatal("the leader refused a proposal")
+	}
+	rf.mu.Lock()
+	rf.flush()
+	rf.mu.Unlock()
+
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 0, Entries: entries(rf, 1, 1)}, &AppendEntriesReply{Term: 1, Success: true})
+	rf.mu.Lock()
+	match, commit := rf.matchIndex[rf.me], rf.commitIndex
+	rf.mu.Unlock()
+	if match != 0 || commit != 0 {
+		t.Fatalf("self-match %d, commitIndex %d while the leader is saving %d, want 0 and 0", match, commit, index)
+	}
+
+	rf.saveMu.Unlock()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		rf.mu.Lock()
+		match, commit = rf.matchIndex[rf.me], rf.commitIndex
+		rf.mu.Unlock()
+		if match == index && commit == index {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("self-match %d, commitIndex %d once %d is saved, want %d and %d", match, commit, index, index, index)
+		}
+	}
+	if rf.persister.RaftStateSize() == 0 {
+		t.Fatal("the batch wasn't saved")
+	}
+}
@@ -0,0 +1,81 @@
+package raft
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Config tunes the timing of a raft, the size of its messages and when its service snapshots.
+// the defaults suit a single data center; across data centers, the heartbeat interval and the
+// election timeout should be well above the round trip time between them.
+type Config struct {
+	HeartbeatInterval  time.Duration
+	ElectionTimeoutMin time.Duration // the election timeout is picked at random in [min, max).
+	ElectionTimeoutMax time.Duration
+
+	MaxInflight   int // the max number of AppendEntries in flight to a follower.
+	MaxMsgBytes   int // the max size of the entries of an AppendEntries, unless a single entry is larger.
+	MaxMsgEntries int // the max number of entries of an AppendEntries, 0 for no limit.
+
+	MaxRaftState int // the size of the raft state at which the service snapshots, -1 for never.
+
+	// the priority of the raft to be leader, see transfer.go. a raft of priority p waits
+	// at most min + (max-min)/(1+p) for an election, for p >= 0.
+	Priority int
+
+	// set for a witness, which votes and counts toward the quorums but keeps no commands,
+	// see witness.go.
+	Witness bool
+}
+
+var DefaultConfig = Config{
+	HeartbeatInterval:  10 * time.Millisecond,
+	ElectionTimeoutMin: 300 * time.Millisecond,
+	ElectionTimeoutMax: 600 * time.Millisecond,
+	MaxInflight:        8,
+	MaxMsgBytes:        1 << 20,
+	MaxRaftState:       8 << 20,
+}
+
+// Validate returns an error if config can't be run with.
+func (config Config) Validate() error {
+	if config.HeartbeatInterval <= 0 {
+		return errors.New("the heartbeat interval must be positive")
+	}
+	if config.ElectionTimeoutMin <= config.HeartbeatInterval {
+		return errors.New("the election timeout must be longer than the heartbeat interval")
+	}
+	if config.ElectionTimeoutMax <= config.ElectionTimeoutMin {
+		return errors.New("the election timeout range is empty")
+	}
+	if config.MaxInflight <= 0 || config.MaxMsgBytes <= 0 || config.MaxMsgEntries < 0 {
+		return errors.New("the message limits must be positive")
+	}
+	if config.Priority < 0 {
+		return errors.New("the priority must not be negative")
+	}
+	if config.Witness && config.Priority > 0 {
+		return errors.New("a witness can't be leader, hence has no priority")
+	}
+	return nil
+}
+
+// RPCTimeout bounds an RPC to a peer, so that a hung peer can't hold up the senders.
+// a reply later than an election timeout would be of no use anyway.
+func (config Config) RPCTimeout() time.Duration {
+	return config.ElectionTimeoutMin
+}
+
+// Config returns the config the raft was made with.
+func (rf *Raft) Config() Config {
+	return rf.config
+}
+
+func (rf *Raft) electionTimeout() time.Duration {
+	spread := (rf.config.ElectionTimeoutMax - rf.config.ElectionTimeoutMin) / time.Duration(1+rf.config.Priority)
+	if spread <= 0 {
+		spread = 1
+	}
+	return rf.config.ElectionTimeoutMin + time.Duration(rand.Int63n(int64(spread)))
+}
@@ -1,14 +1,12 @@
 package raft
 
-import (
-	"log"
-	"time"
-)
+import "log"
 
 // example RequestVote RPC arguments structure.
 // field names must start with capital letters!
 type RequestVoteArgs struct {
 	// Your data here (2A, 2B).
+	Group        int
 	Term         int
 	CandidateId  int
 	LastLogIndex int
@@ -38,6 +36,10 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) erro
 
 	reply.Term = rf.currentTerm
 	lastLog := rf.log.lastEntry()
+	if rf.config.Witness {
+		// a witness only holds out for the entries it pinned, see witness.go.
+		lastLog = Entry{Term: rf.pinnedTerm, Index: rf.pinnedIndex}
+	}
 	upToDate := args.LastLogTerm > lastLog.Term ||
 		(args.LastLogTerm == lastLog.Term && args.LastLogIndex >= lastLog.Index)
 
@@ -57,7 +59,7 @@ func (rf *Raft) sendRequestVote(
 	reply *RequestVoteReply,
 	votes *int,
 ) {
-	ok := rf.peers[server].Call("Raft.RequestVote", args, reply)
+	ok := rf.call(server, "Raft.RequestVote", args, reply)
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 	if !ok {
@@ -81,10 +83,15 @@ func (rf *Raft) sendRequestVote(
 			rf.matchIndex[peer] = 0
 			rf.nextIndex[peer] = lastLogIndex
 		}
+		rf.resetProgress()
+		rf.transferee = -1
+		// note: the log is saved already, as a follower saves it before it acks.
+		rf.matchIndex[rf.me] = lastLogIndex - 1
 		rf.state = Leader
+		rf.leaderId = rf.me
 		rf.heartBeatTimer.Stop()
 		log.Println("I am the leader")
-		rf.heartBeatTimer.Reset(10 * time.Millisecond)
+		rf.heartBeatTimer.Reset(rf.config.HeartbeatInterval)
 		rf.leaderAppendEntries()
 	}
 }
@@ -96,6 +103,7 @@ func (rf *Raft) candidateRequestVote(votes *int) {
 		}
 		args := RequestVoteArgs{}
 		reply := RequestVoteReply{}
+		args.Group = rf.group
 		args.CandidateId = rf.me
 		args.Term = rf.currentTerm
 		lastLog := rf.log.lastEntry()
@@ -108,9 +116,15 @@ func (rf *Raft) candidateRequestVote(votes *int) {
 func (rf *Raft) startElection() {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
+	if rf.config.Witness {
+		// a witness lacks the commands to lead with.
+		rf.resetElection()
+		return
+	}
 	votes := 1
 	rf.currentTerm += 1
 	rf.votedFor = rf.me
+	rf.leaderId = -1
 	rf.state = Candidate
 	rf.persist()
 	rf.resetElection()
@@ -0,0 +1,79 @@
+package raft
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"DDB/client"
+)
+
+// Heartbeat is an AppendEntries without entries, left to the multi-raft host
+// so that it can coalesce the heartbeats of all its groups to the same peer host.
+type Heartbeat struct {
+	Peer int
+	To   *client.Client
+	Args AppendEntriesArgs
+}
+
+// HeartbeatArgs carries the heartbeats of many groups from one host to another.
+type HeartbeatArgs struct {
+	Beats []AppendEntriesArgs
+}
+
+type HeartbeatReply struct {
+	Replies []AppendEntriesReply
+	Found   []bool // whether the group of each heartbeat is hosted by the receiver.
+}
+
+// Tick drives a hosted raft in place of its timers. it starts an election once the
+// election timeout has elapsed, and returns the heartbeats for the host to send if leader.
+func (rf *Raft) Tick() []Heartbeat {
+	rf.mu.Lock()
+	if rf.state != Leader && time.Now().After(rf.electionDeadline) {
+		rf.mu.Unlock()
+		rf.startElection()
+		return nil
+	}
+	defer rf.mu.Unlock()
+	if rf.state != Leader {
+		return nil
+	}
+	return rf.leaderHeartbeats(true)
+}
+
+func (rf *Raft) Group() int {
+	return rf.group
+}
+
+// Storage is the storage engine shared by all the groups of a multi-raft host.
+type Storage struct {
+	mu         sync.Mutex
+	dir        string             // the directory of the files of the groups, or empty for memory only.
+	persisters map[int]*Persister // group -> persister
+}
+
+// MakeStorage makes the storage of a host, which keeps the state of each group in a file of
+// dir, as a file persister does for a single group, or in memory only if dir is empty.
+func MakeStorage(dir string) *Storage {
+	return &Storage{dir: dir, persisters: make(map[int]*Persister)}
+}
+
+// Persister returns the persister of group, making it if needed from the file of the group.
+func (st *Storage) Persister(group int) (*Persister, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if ps, ok := st.persisters[group]; ok {
+		return ps, nil
+	}
+	ps := MakePersister()
+	if st.dir != "" {
+		var err error
+		if ps, err = MakeFilePersister(filepath.Join(st.dir, fmt.Sprint("group-", group))); err != nil {
+			return nil, err
+		}
+	}
+	st.persisters[group] = ps
+	return ps, nil
+}
@@ -5,6 +5,7 @@ import (
 )
 
 type InitArgs struct {
+	Group  int
 	Client []*client.Client
 }
 
@@ -21,16 +22,44 @@ func (rf *Raft) containPeer(cl *client.Client) bool {
 	return false
 }
 
+// indexOf returns the index of the peer at address, or -1.
+func (rf *Raft) indexOf(address string) int {
+	for i, peer := range rf.peers {
+		if peer.Address() == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// Peers returns the RPC end points of all peers.
+func (rf *Raft) Peers() []*client.Client {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return append([]*client.Client{}, rf.peers...)
+}
+
 func (rf *Raft) Init(args *InitArgs, reply *InitReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	// the clients come off the wire without their transport, so they're dialed again over ours.
+	for i, peer := range args.Client {
+		args.Client[i] = rf.peers[rf.me].Dial(peer.Address())
+	}
 	for _, peer := range args.Client {
 		if !rf.containPeer(peer) {
 			rf.peers = append(rf.peers, peer)
 		}
 	}
-	rf.peers = args.Client
+	// note: the peers are merged rather than replaced, since rf.me must keep indexing this server.
 	reply.Client = rf.peers
-	rf.matchIndex = make([]int, len(rf.peers))
-	rf.nextIndex = make([]int, len(rf.peers))
+	for len(rf.matchIndex) < len(rf.peers) {
+		rf.matchIndex = append(rf.matchIndex, 0)
+		rf.nextIndex = append(rf.nextIndex, rf.log.lastEntry().Index+1)
+		rf.progress = append(rf.progress, &progress{state: Probe})
+		rf.priorities = append(rf.priorities, 0)
+		rf.witnesses = append(rf.witnesses, false)
+	}
 	return nil
 }
 
@@ -41,11 +70,12 @@ func (rf *Raft) setInit() {
 		}
 		args := InitArgs{}
 		reply := InitReply{}
+		args.Group = rf.group
 		args.Client = rf.peers
-		rf.peers[peer].Call("Raft.Init", args, reply)
+		rf.call(peer, "Raft.Init", args, reply)
 		for _, peer := range reply.Client {
 			if !rf.containPeer(peer) {
-				rf.peers = append(rf.peers, peer)
+				rf.peers = append(rf.peers, rf.peers[rf.me].Dial(peer.Address()))
 			}
 		}
 	}
@@ -9,6 +9,7 @@ type Entry struct {
 	Command interface{}
 	Term    int
 	Index   int
+	Size    int // the encoded size of the entry, to bound the size of an AppendEntries.
 }
 
 func (log *Log) length() int {
@@ -9,18 +9,72 @@ package raft
 // test with the original before submitting.
 //
 
-import "sync"
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
 
 type Persister struct {
 	mu        sync.Mutex
 	raftstate []byte
 	snapshot  []byte
+	path      string // the file the states are saved to, or empty to keep them in memory only.
 }
 
 func MakePersister() *Persister {
 	return &Persister{}
 }
 
+// MakeFilePersister makes a persister that saves the states to the file at path, and
+// starts from the states saved there, if any, e.g. upon a restart.
+func MakeFilePersister(path string) (*Persister, error) {
+	ps := &Persister{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the file holds the size of the raft state, the raft state and the snapshot.
+	if len(data) < 8 || binary.BigEndian.Uint64(data) > uint64(len(data)-8) {
+		return nil, errors.New(path + ": corrupt raft state")
+	}
+	size := 8 + int(binary.BigEndian.Uint64(data))
+	ps.raftstate = data[8:size]
+	ps.snapshot = data[size:]
+	return ps, nil
+}
+
+// write saves the states to the file, through a temporary file renamed over it, so that
+// a crash leaves either the previous states or the new ones. the caller holds ps.mu.
+func (ps *Persister) write() error {
+	tmp := ps.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(ps.raftstate)))
+	for _, b := range [][]byte{size, ps.raftstate, ps.snapshot} {
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path)
+}
+
 func clone(orig []byte) []byte {
 	x := make([]byte, len(orig))
 	copy(x, orig)
@@ -31,8 +85,8 @@ func (ps *Persister) Copy() *Persister {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	np := MakePersister()
-	// np.raftstate = ps.raftstate
-	// np.snapshot = ps.snapshot
+	np.raftstate = ps.raftstate
+	np.snapshot = ps.snapshot
 	return np
 }
 
@@ -53,8 +107,14 @@ func (ps *Persister) RaftStateSize() int {
 func (ps *Persister) Save(raftstate []byte, snapshot []byte) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	// ps.raftstate = clone(raftstate)
-	// ps.snapshot = clone(snapshot)
+	ps.raftstate = clone(raftstate)
+	ps.snapshot = clone(snapshot)
+	if ps.path != "" {
+		// note: raft can't go on without its state saved.
+		if err := ps.write(); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func (ps *Persister) ReadSnapshot() []byte {
@@ -0,0 +1,26 @@
+package raft
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePersister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft")
+	ps, err := MakeFilePersister(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.Save([]byte("state"), []byte("snapshot"))
+	ps.Save([]byte("state 2"), nil)
+
+	// a restart reads the last states saved.
+	ps, err = MakeFilePersister(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ps.ReadRaftState(), []byte("state 2")) || ps.SnapshotSize() != 0 {
+		t.Fatalf("read %q and a snapshot of %d bytes, want %q and none", ps.ReadRaftState(), ps.SnapshotSize(), "state 2")
+	}
+}
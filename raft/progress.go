@@ -0,0 +1,164 @@
+package raft
+
+//
+// the leader tracks the replication of its log to each follower with a progress,
+// in the manner of the progress tracker of etcd:
+//   probe      the leader doesn't know how much of its log the follower has. it sends
+//              one AppendEntries per heartbeat interval, backing off nextIndex on rejects.
+//   replicate  the follower accepted an AppendEntries, so the leader streams the entries:
+//              it advances nextIndex as soon as it sends them, with up to MaxInflight
+//              AppendEntries in flight. a reject or a lost AppendEntries sends it back to probe.
+//   snapshot   the follower needs entries that were compacted, so a snapshot is on its way.
+// each AppendEntries carries up to MaxMsgBytes and MaxMsgEntries of entries.
+//
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+type ProgressState string
+
+const (
+	Probe        ProgressState = "PROBE"
+	Replicate    ProgressState = "REPLICATE"
+	Snapshotting ProgressState = "SNAPSHOT"
+)
+
+type progress struct {
+	state     ProgressState
+	inflight  []int     // the last index sent by each AppendEntries in flight, in replicate state.
+	probeSent time.Time // when the last probe was sent, in probe state.
+	replied   time.Time // when the follower last replied, to tell whether it's up.
+	pinned    int       // the highest index the follower acked pinned, for a witness.
+}
+
+func (pr *progress) becomeProbe() {
+	pr.state = Probe
+	pr.inflight = nil
+	pr.probeSent = time.Time{}
+}
+
+func (pr *progress) becomeReplicate() {
+	pr.state = Replicate
+	pr.inflight = nil
+}
+
+// ack frees the AppendEntries in flight that are acknowledged by the follower matching match.
+func (pr *progress) ack(match int) {
+	i := 0
+	for i < len(pr.inflight) && pr.inflight[i] <= match {
+		i++
+	}
+	pr.inflight = pr.inflight[i:]
+}
+
+// resetProgress makes the progress of every follower start over from probe, e.g. upon election.
+// the followers are taken to be up until they fail to reply for an election timeout.
+func (rf *Raft) resetProgress() {
+	rf.progress = make([]*progress, len(rf.peers))
+	for peer := range rf.peers {
+		rf.progress[peer] = &progress{state: Probe, replied: time.Now()}
+	}
+}
+
+// replicate sends what follower peer needs next, according to its progress. the heartbeats
+// without entries are added to beats rather than sent if coalesced is set. the caller holds rf.mu.
+func (rf *Raft) replicate(peer int, coalesced bool, beats []Heartbeat) []Heartbeat {
+	pr := rf.progress[peer]
+	last := rf.log.lastEntry().Index
+	if rf.nextIndex[peer] > last+1 {
+		rf.nextIndex[peer] = last + 1
+	}
+	if rf.nextIndex[peer]-1 < rf.log.FirstIndex {
+		if pr.state != Snapshotting {
+			pr.state = Snapshotting
+			args := rf.installSnapshotArgs()
+			if rf.witnesses[peer] {
+				args.Data = nil
+			}
+			go rf.sendInstallSnapshot(peer, args)
+		}
+		return beats
+	}
+
+	switch pr.state {
+	case Probe:
+		if time.Since(pr.probeSent) >= rf.config.HeartbeatInterval {
+			pr.probeSent = time.Now()
+			return rf.sendEntries(peer, rf.nextIndex[peer], true, coalesced, beats)
+		}
+		return rf.sendEntries(peer, rf.nextIndex[peer], false, coalesced, beats)
+
+	case Replicate:
+		sent := false
+		for len(pr.inflight) < rf.config.MaxInflight && rf.nextIndex[peer] <= last {
+			beats = rf.sendEntries(peer, rf.nextIndex[peer], true, coalesced, beats)
+			sent = true
+		}
+		if !sent {
+			// a heartbeat from the match of the follower, which the follower accepts
+			// whatever AppendEntries are still in flight, unless the match is compacted.
+			beats = rf.sendEntries(peer, max(rf.matchIndex[peer], rf.log.FirstIndex)+1, false, coalesced, beats)
+		}
+	}
+	return beats
+}
+
+// sendEntries sends an AppendEntries to peer from index next on, with entries if withEntries
+// is set, or else as a heartbeat. in replicate state, nextIndex moves past the entries sent.
+func (rf *Raft) sendEntries(peer int, next int, withEntries bool, coalesced bool, beats []Heartbeat) []Heartbeat {
+	prevLog := rf.log.at(next - 1)
+	args := AppendEntriesArgs{}
+	reply := AppendEntriesReply{}
+	args.Group = rf.group
+	args.Term = rf.currentTerm
+	args.LeaderId = rf.me
+	args.Leader = rf.peers[rf.me].Address()
+	args.LeaderCommit = rf.commitIndex
+	args.PrevLogTerm = prevLog.Term
+	args.PrevLogIndex = next - 1
+	args.Pin = rf.witnesses[peer] && !rf.replicasUp()
+	if withEntries {
+		args.Entries = rf.entriesFrom(next)
+		if rf.witnesses[peer] {
+			stripped(args.Entries)
+		}
+	}
+	if pr := rf.progress[peer]; pr.state == Replicate && len(args.Entries) > 0 {
+		sent := args.Entries[len(args.Entries)-1].Index
+		pr.inflight = append(pr.inflight, sent)
+		rf.nextIndex[peer] = sent + 1
+	}
+	if coalesced && len(args.Entries) == 0 {
+		return append(beats, Heartbeat{Peer: peer, To: rf.peers[peer], Args: args})
+	}
+	go rf.sendAppendEntries(peer, &args, &reply)
+	return beats
+}
+
+// entriesFrom returns a copy of the entries from index on, up to MaxMsgBytes and
+// MaxMsgEntries of them but at least one, if any.
+func (rf *Raft) entriesFrom(index int) []Entry {
+	entries := rf.log.sliceToEnd(index)
+	if rf.config.MaxMsgEntries > 0 && len(entries) > rf.config.MaxMsgEntries {
+		entries = entries[:rf.config.MaxMsgEntries]
+	}
+	size := 0
+	for i := range entries {
+		size += entries[i].Size
+		if i > 0 && size > rf.config.MaxMsgBytes {
+			entries = entries[:i]
+			break
+		}
+	}
+	return append([]Entry(nil), entries...)
+}
+
+// entrySize returns the size of e once encoded, which is computed once by the leader that starts it.
+func entrySize(e *Entry) int {
+	w := new(bytes.Buffer)
+	gob.NewEncoder(w).Encode(e)
+	return w.Len()
+}
@@ -0,0 +1,169 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"DDB/client"
+)
+
+// rpc is an RPC held by a stalled network, until the test fails it or it's abandoned.
+type rpc struct {
+	name   string
+	args   interface{}
+	result chan bool
+}
+
+// stalled is a network whose RPCs are held rather than delivered, for a test to look at
+// what a leader sends, and to play the replies itself through HandleHeartbeat.
+type stalled struct {
+	sent chan *rpc
+}
+
+func (s *stalled) Call(ctx context.Context, address string, rpcname string, args interface{}, reply interface{}) bool {
+	r := &rpc{name: rpcname, args: args, result: make(chan bool, 1)}
+	s.sent <- r
+	select {
+	case ok := <-r.result:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// next returns the next RPC sent.
+func (s *stalled) next(t *testing.T) *rpc {
+	t.Helper()
+	select {
+	case r := <-s.sent:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("no RPC sent")
+		return nil
+	}
+}
+
+// appends returns the next n AppendEntries sent, in any order.
+func (s *stalled) appends(t *testing.T, n int) []*AppendEntriesArgs {
+	t.Helper()
+	sent := []*AppendEntriesArgs{}
+	for len(sent) < n {
+		if r := s.next(t); r.name == "Raft.AppendEntries" {
+			sent = append(sent, r.args.(*AppendEntriesArgs))
+		}
+	}
+	return sent
+}
+
+// connected makes the leader of term of a group of n on a stalled network, with a log of entries
+// of the given terms, as just elected. its timers and RPCs don't expire within a test.
+func connected(t *testing.T, config Config, term int, n int, terms ...int) (*Raft, *stalled) {
+	rf := leader(term, n, terms...)
+	s := &stalled{sent: make(chan *rpc, 100)}
+	for i := 0; i < n; i++ {
+		rf.peers = append(rf.peers, client.MakeClientVia(s, fmt.Sprint("p", i)))
+		rf.nextIndex = append(rf.nextIndex, rf.log.lastEntry().Index+1)
+	}
+	config.ElectionTimeoutMin = time.Hour
+	config.ElectionTimeoutMax = 2 * time.Hour
+	rf.config = config
+	rf.persister = MakePersister()
+	rf.electionTimer = time.NewTimer(time.Hour)
+	rf.heartBeatTimer = time.NewTimer(time.Hour)
+	rf.priorities = make([]int, n)
+	rf.transferee = -1
+	rf.resetProgress()
+	rf.ctx, rf.cancel = context.WithCancel(context.Background())
+	t.Cleanup(rf.Kill)
+	return rf, s
+}
+
+// entries returns the entries of the log of rf in [from, to].
+func entries(rf *Raft, from int, to int) []Entry {
+	return append([]Entry(nil), rf.log.sliceToEnd(from)[:to-from+1]...)
+}
+
+// a follower in probe is sent entries once per heartbeat interval, and only heartbeats
+// in between, until it accepts some.
+func TestProbeSendsEntriesOncePerInterval(t *testing.T) {
+	rf, s := connected(t, DefaultConfig, 1, 2, 1, 1, 1)
+	rf.mu.Lock()
+	rf.nextIndex[1] = 1
+	rf.replicate(1, false, nil)
+	rf.replicate(1, false, nil)
+	rf.mu.Unlock()
+
+	withEntries := 0
+	for _, args := range s.appends(t, 2) {
+		if len(args.Entries) > 0 {
+			withEntries++
+		}
+	}
+	if withEntries != 1 {
+		t.Fatalf("%d of 2 AppendEntries sent in an interval carry entries, want 1", withEntries)
+	}
+	if pr := rf.progress[1]; pr.state != Probe || rf.nextIndex[1] != 1 {
+		t.Fatalf("in %s from %d after probing, want %s from 1", pr.state, rf.nextIndex[1], Probe)
+	}
+}
+
+// once a follower accepts a probe, the leader streams it the entries, with no more than
+// MaxInflight AppendEntries in flight, and sends more as they're acknowledged.
+func TestReplicateInflightWindow(t *testing.T) {
+	config := DefaultConfig
+	config.MaxInflight = 2
+	config.MaxMsgEntries = 1
+	rf, s := connected(t, config, 1, 2, 1, 1, 1, 1, 1)
+
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 0, Entries: entries(rf, 1, 1)}, &AppendEntriesReply{Term: 1, Success: true})
+	if pr := rf.progress[1]; pr.state != Replicate || rf.nextIndex[1] != 2 {
+		t.Fatalf("in %s from %d once a probe of 1 is accepted, want %s from 2", pr.state, rf.nextIndex[1], Replicate)
+	}
+
+	rf.mu.Lock()
+	rf.replicate(1, false, nil)
+	rf.replicate(1, false, nil)
+	rf.mu.Unlock()
+	sent := map[int]bool{}
+	for _, args := range s.appends(t, 3) {
+		if len(args.Entries) > 0 {
+			sent[args.Entries[0].Index] = true
+		}
+	}
+	if len(sent) != 2 || !sent[2] || !sent[3] {
+		t.Fatalf("sent entries %v with 2 in flight at most, want 2 and 3", sent)
+	}
+	if rf.nextIndex[1] != 4 {
+		t.Fatalf("nextIndex = %d with 2 and 3 in flight, want 4", rf.nextIndex[1])
+	}
+
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 1, Entries: entries(rf, 2, 2)}, &AppendEntriesReply{Term: 1, Success: true})
+	rf.mu.Lock()
+	rf.replicate(1, false, nil)
+	rf.mu.Unlock()
+	if args := s.appends(t, 1)[0]; len(args.Entries) != 1 || args.Entries[0].Index != 4 {
+		t.Fatalf("sent %v once 2 is acknowledged, want 4", args.Entries)
+	}
+	if pr := rf.progress[1]; len(pr.inflight) != 2 || rf.nextIndex[1] != 5 {
+		t.Fatalf("%v in flight, nextIndex = %d, want [3 4] and 5", pr.inflight, rf.nextIndex[1])
+	}
+}
+
+// a reject of a follower in replicate sends it back to probe, from where it conflicts.
+func TestRejectProbesAgain(t *testing.T) {
+	rf, _ := connected(t, DefaultConfig, 2, 2, 1, 1, 2, 2)
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 2, PrevLogIndex: 0}, &AppendEntriesReply{Term: 2, Success: true})
+	rf.mu.Lock()
+	rf.replicate(1, false, nil)
+	rf.mu.Unlock()
+	if pr := rf.progress[1]; pr.state != Replicate || len(pr.inflight) == 0 {
+		t.Fatalf("in %s with %v in flight, want %s with entries in flight", pr.state, pr.inflight, Replicate)
+	}
+
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 2, PrevLogIndex: 0, Entries: entries(rf, 1, 4)}, &AppendEntriesReply{Term: 2, Conflict: true, XTerm: -1, XLen: 2})
+	if pr := rf.progress[1]; pr.state != Probe || len(pr.inflight) != 0 || rf.nextIndex[1] != 2 {
+		t.Fatalf("in %s from %d with %v in flight after a reject, want %s from 2 with none", pr.state, rf.nextIndex[1], pr.inflight, Probe)
+	}
+}
@@ -19,7 +19,7 @@ package raft
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -41,6 +41,7 @@ type ApplyMsg struct {
 	CommandValid bool
 	Command      interface{}
 	CommandIndex int
+	CommandTerm  int
 
 	// For 2D:
 	SnapshotValid bool
@@ -78,9 +79,43 @@ type Raft struct {
 	lastApplied    int
 	nextIndex      []int
 	matchIndex     []int
+	progress       []*progress // the replication state of each follower, on the leader.
 	snapshot       Snapshot
 	log            Log
 	ch             chan ApplyMsg
+	leaderId       int // the leader of the current term, or -1 if not known yet.
+	batched        int // the entries appended by Start, but neither persisted nor sent yet.
+
+	snapshotPending bool // set once a snapshot from the leader is installed, until it's applied.
+
+	// the last entry a leader has pinned, on a witness, see witness.go.
+	pinnedTerm  int
+	pinnedIndex int
+
+	// the states are saved in the order they're encoded in, though the leader saves them in the background.
+	persistSeq int        // the number of states encoded.
+	saveMu     sync.Mutex // held while saving, after rf.mu if both are.
+	savedSeq   int        // the number of the last state saved.
+
+	config Config
+
+	// the priorities reported by the followers, which of them are witnesses, and the
+	// leadership transfer in progress, on the leader.
+	priorities       []int
+	witnesses        []bool
+	transferee       int  // the follower being handed leadership, or -1.
+	timeoutSent      bool // whether the transferee has been told to stand for election.
+	transferTime     time.Time
+	transferDeadline time.Time
+
+	// set for the rafts of a multi-raft host, which are driven by Tick rather than by their timers.
+	group            int
+	hosted           bool
+	electionDeadline time.Time
+
+	// cancelled by Kill() to abandon the outstanding RPCs.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // return currentTerm and whether this server
@@ -92,6 +127,27 @@ func (rf *Raft) GetState() (int, bool) {
 	return rf.currentTerm, rf.state == Leader
 }
 
+// Leader returns the peer believed to lead the current term, if any.
+func (rf *Raft) Leader() (*client.Client, bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.leaderId < 0 || rf.leaderId >= len(rf.peers) {
+		return nil, false
+	}
+	return rf.peers[rf.leaderId], true
+}
+
+// LeaderHint returns the index in peers and the address of the leader of the current term,
+// along with the term. the index is -1 and the address empty if the leader isn't known.
+func (rf *Raft) LeaderHint() (int, int, string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.leaderId < 0 || rf.leaderId >= len(rf.peers) {
+		return -1, rf.currentTerm, ""
+	}
+	return rf.leaderId, rf.currentTerm, rf.peers[rf.leaderId].Address()
+}
+
 // save Raft's persistent state to stable storage,
 // where it can later be retrieved after a crash and restart.
 // see paper's Figure 2 for a description of what should be persistent.
@@ -100,25 +156,34 @@ func (rf *Raft) GetState() (int, bool) {
 // after you've implemented snapshots, pass the current snapshot
 // (or nil if there's not yet a snapshot).
 func (rf *Raft) persist() {
+	rf.persistSeq++
+	rf.save(rf.persistSeq, rf.encodeState(), rf.snapshot.Data)
+}
+
+// save saves the state encoded as the seq-th, unless a later one is saved already,
+// e.g. by persistAsync.
+func (rf *Raft) save(seq int, raftstate []byte, snapshot []byte) {
+	rf.saveMu.Lock()
+	defer rf.saveMu.Unlock()
+	if seq > rf.savedSeq {
+		rf.persister.Save(raftstate, snapshot)
+		rf.savedSeq = seq
+	}
+}
+
+func (rf *Raft) encodeState() []byte {
 	w := new(bytes.Buffer)
-	// e := labgob.NewEncoder(w)
-	// if e.Encode(rf.currentTerm) != nil {
-	// 	return
-	// }
-	// if e.Encode(rf.votedFor) != nil {
-	// 	return
-	// }
-	// if e.Encode(rf.log) != nil {
-	// 	return
-	// }
-	// if e.Encode(rf.snapshot.Index) != nil {
-	// 	return
-	// }
-	// if e.Encode(rf.snapshot.Term) != nil {
-	// 	return
-	// }
-	raftstate := w.Bytes()
-	rf.persister.Save(raftstate, rf.snapshot.Data)
+	e := labgob.NewEncoder(w)
+	if e.Encode(rf.currentTerm) != nil ||
+		e.Encode(rf.votedFor) != nil ||
+		e.Encode(rf.log) != nil ||
+		e.Encode(rf.snapshot.Index) != nil ||
+		e.Encode(rf.snapshot.Term) != nil ||
+		e.Encode(rf.pinnedTerm) != nil ||
+		e.Encode(rf.pinnedIndex) != nil {
+		panic("failed to encode the raft state")
+	}
+	return w.Bytes()
 }
 
 // restore previously persisted state.
@@ -133,11 +198,15 @@ func (rf *Raft) readPersist(data []byte) {
 	var log Log
 	var index int
 	var term int
+	var pinnedTerm int
+	var pinnedIndex int
 	if d.Decode(&currentTerm) != nil ||
 		d.Decode(&votedFor) != nil ||
 		d.Decode(&log) != nil ||
 		d.Decode(&index) != nil ||
-		d.Decode(&term) != nil {
+		d.Decode(&term) != nil ||
+		d.Decode(&pinnedTerm) != nil ||
+		d.Decode(&pinnedIndex) != nil {
 		panic("fail")
 	} else {
 		rf.currentTerm = currentTerm
@@ -145,6 +214,8 @@ func (rf *Raft) readPersist(data []byte) {
 		rf.log = log
 		rf.snapshot.Index = index
 		rf.snapshot.Term = term
+		rf.pinnedTerm = pinnedTerm
+		rf.pinnedIndex = pinnedIndex
 		rf.snapshot.Data = rf.persister.ReadSnapshot()
 		rf.log.compactedTo(rf.snapshot.Index, rf.snapshot.Term)
 		rf.commitIndex = rf.snapshot.Index
@@ -158,17 +229,17 @@ func (rf *Raft) readPersist(data []byte) {
 // that index. Raft should now trim its log as much as possible.
 func (rf *Raft) Snapshot(index int, snapshot []byte) {
 	// Your code here (2D).
-	fmt.Println(rf.snapshot.Index)
-	if index < rf.snapshot.Index {
-		return
-	}
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
+	if index <= rf.snapshot.Index {
+		// e.g. a snapshot from the leader was installed meanwhile.
+		return
+	}
 
-	rf.log.compactedTo(index, rf.log.at(index).Term)
-	rf.persist()
+	term := rf.log.at(index).Term
+	rf.log.compactedTo(index, term)
 	rf.snapshot = Snapshot{
-		Term:  rf.currentTerm,
+		Term:  term,
 		Index: index,
 		Data:  snapshot,
 	}
@@ -190,7 +261,7 @@ func (rf *Raft) Snapshot(index int, snapshot []byte) {
 func (rf *Raft) Start(command interface{}) (int, int, bool) {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
-	if rf.state != Leader {
+	if rf.state != Leader || rf.transferring() {
 		return -1, -1, false
 	}
 
@@ -204,9 +275,9 @@ func (rf *Raft) Start(command interface{}) (int, int, bool) {
 		Term:    term,
 	}
 
+	log.Size = entrySize(&log)
 	rf.log.appendLog(log)
-	rf.persist()
-	rf.leaderAppendEntries()
+	rf.batch()
 
 	return index, term, true
 }
@@ -223,6 +294,7 @@ func (rf *Raft) Start(command interface{}) (int, int, bool) {
 func (rf *Raft) Kill() {
 	atomic.StoreInt32(&rf.dead, 1)
 	// Your code here, if desired.
+	rf.cancel()
 }
 
 func (rf *Raft) killed() bool {
@@ -240,7 +312,7 @@ func (rf *Raft) ticker() {
 			if rf.state == Leader {
 				rf.leaderAppendEntries()
 				rf.heartBeatTimer.Stop()
-				rf.heartBeatTimer.Reset(10 * time.Millisecond)
+				rf.heartBeatTimer.Reset(rf.config.HeartbeatInterval)
 			}
 			rf.mu.Unlock()
 		}
@@ -255,24 +327,45 @@ func (rf *Raft) ticker() {
 // recent saved state, if any. applyCh is a channel on which the
 // tester or service expects Raft to send ApplyMsg messages.
 // Make() must return quickly, so it should start goroutines
-// for any long-running work.
+// for any long-running work. config must be valid, e.g. DefaultConfig.
 func Make(peers []*client.Client, me int,
-	persister *Persister, applyCh chan ApplyMsg) *Raft {
+	persister *Persister, applyCh chan ApplyMsg, config Config) *Raft {
+	return makeRaft(peers, me, persister, applyCh, config, 0, false)
+}
+
+// MakeHosted creates the raft of group to be run by a multi-raft host. it shares
+// the transport and the timers of the host, which calls Tick periodically.
+func MakeHosted(peers []*client.Client, me int,
+	persister *Persister, applyCh chan ApplyMsg, config Config, group int) *Raft {
+	return makeRaft(peers, me, persister, applyCh, config, group, true)
+}
+
+func makeRaft(peers []*client.Client, me int,
+	persister *Persister, applyCh chan ApplyMsg, config Config, group int, hosted bool) *Raft {
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
 	rf := &Raft{}
+	rf.config = config
 	rf.peers = peers
 	rf.persister = persister
 	rf.me = me
+	rf.group = group
+	rf.hosted = hosted
+	rf.ctx, rf.cancel = context.WithCancel(context.Background())
 
 	// Your initialization code here (2A, 2B, 2C).
-	rf.heartBeatTimer = time.NewTimer(10 * time.Millisecond)
-	rf.electionTimer = time.NewTimer(randTime())
+	rf.heartBeatTimer = time.NewTimer(rf.config.HeartbeatInterval)
+	rf.electionTimer = time.NewTimer(rf.electionTimeout())
 	rf.heartBeatTimer.Stop()
+	rf.resetElection()
 	rf.applyCond = sync.NewCond(&rf.mu)
 
 	rf.state = Follower
 
 	rf.currentTerm = 0
 	rf.votedFor = -1
+	rf.leaderId = -1
 	rf.commitIndex = 0
 	rf.lastApplied = 0
 
@@ -289,6 +382,10 @@ func Make(peers []*client.Client, me int,
 	rf.log.Entries = append(rf.log.Entries, firstEntry)
 	rf.nextIndex = make([]int, len(rf.peers))
 	rf.matchIndex = make([]int, len(rf.peers))
+	rf.resetProgress()
+	rf.priorities = make([]int, len(rf.peers))
+	rf.witnesses = make([]bool, len(rf.peers))
+	rf.transferee = -1
 	rf.snapshot = Snapshot{}
 
 	rf.ch = applyCh
@@ -297,7 +394,9 @@ func Make(peers []*client.Client, me int,
 	rf.readPersist(persister.ReadRaftState())
 
 	// start ticker goroutine to start elections
-	go rf.ticker()
+	if !rf.hosted {
+		go rf.ticker()
+	}
 	go rf.applier()
 
 	rf.setInit()
@@ -313,12 +412,26 @@ func (rf *Raft) applier() {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 	for !rf.killed() {
-		if rf.commitIndex > rf.lastApplied {
+		if rf.snapshotPending {
+			rf.snapshotPending = false
+			msg := ApplyMsg{
+				SnapshotValid: true,
+				Snapshot:      rf.snapshot.Data,
+				SnapshotTerm:  rf.snapshot.Term,
+				SnapshotIndex: rf.snapshot.Index,
+			}
+			rf.mu.Unlock()
+			rf.ch <- msg
+			rf.mu.Lock()
+		} else if rf.commitIndex > rf.lastApplied && rf.config.Witness {
+			rf.skipApplied()
+		} else if rf.commitIndex > rf.lastApplied {
 			rf.lastApplied += 1
 			msg := ApplyMsg{
 				CommandValid: true,
 				Command:      rf.log.at(rf.lastApplied).Command,
 				CommandIndex: rf.log.at(rf.lastApplied).Index,
+				CommandTerm:  rf.log.at(rf.lastApplied).Term,
 			}
 			rf.mu.Unlock()
 			rf.ch <- msg
@@ -7,6 +7,7 @@ type Snapshot struct {
 }
 
 type InstallSnapshotArgs struct {
+	Group             int
 	Term              int
 	LeaderId          int
 	LastIncludedIndex int
@@ -26,47 +27,65 @@ func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapsho
 	if args.Term < rf.currentTerm {
 		return nil
 	}
+	if args.Term > rf.currentTerm {
+		rf.becomeFollower(args.Term)
+	}
+	rf.resetElection()
+	rf.state = Follower
 	if args.LastIncludedIndex <= rf.commitIndex {
 		reply.CaughtUp = true
 		return nil
 	}
-	rf.becomeFollower(args.Term)
 
-	rf.log.compactedTo(args.LastIncludedIndex, args.LastIncludedTerm)
+	index, term := args.LastIncludedIndex, args.LastIncludedTerm
+	if rf.log.lastEntry().Index >= index && rf.log.at(index).Term == term {
+		// the entries after the snapshot match the leader's, so they're kept.
+		rf.log.compactedTo(index, term)
+	} else {
+		rf.log = Log{Entries: []Entry{{Index: index, Term: term}}, FirstIndex: index}
+		if rf.config.Witness {
+			rf.unpin(index)
+		}
+	}
+	rf.snapshot = Snapshot{Term: term, Index: index, Data: args.Data}
+	if rf.config.Witness {
+		rf.snapshot.Data = nil
+	}
+	rf.lastApplied = index
+	rf.commitIndex = index
 	rf.persist()
-	rf.lastApplied = args.LastIncludedIndex
-	rf.commitIndex = args.LastIncludedIndex
-
 	reply.CaughtUp = true
-	rf.snapshot.Data = args.Data
-	rf.snapshot.Index = args.LastIncludedIndex
-	rf.snapshot.Term = args.LastIncludedTerm
 
-	msg := ApplyMsg{
-		SnapshotValid: true,
-		Snapshot:      args.Data,
-		SnapshotTerm:  args.LastIncludedTerm,
-		SnapshotIndex: args.LastIncludedIndex,
-	}
-	rf.ch <- msg
+	// handed to the service by the applier, in order with the commands.
+	rf.snapshotPending = !rf.config.Witness
+	rf.apply()
 	return nil
 }
 
-func (rf *Raft) sendInstallSnapshot(server int) {
+// installSnapshotArgs makes the args to send the current snapshot. the caller holds rf.mu.
+func (rf *Raft) installSnapshotArgs() *InstallSnapshotArgs {
 	args := InstallSnapshotArgs{}
-	reply := InstallSnapshotReply{}
-
+	args.Group = rf.group
 	args.Term = rf.currentTerm
 	args.LeaderId = rf.me
 	args.LastIncludedIndex = rf.snapshot.Index
 	args.LastIncludedTerm = rf.snapshot.Term
 	args.Data = rf.snapshot.Data
+	return &args
+}
 
-	ok := rf.peers[server].Call("Raft.InstallSnapshot", &args, &reply)
+func (rf *Raft) sendInstallSnapshot(server int, args *InstallSnapshotArgs) {
+	reply := InstallSnapshotReply{}
+	ok := rf.callTimeout(server, "Raft.InstallSnapshot", args, &reply, snapshotTimeout)
 
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
+	if args.Term != rf.currentTerm || rf.state != Leader {
+		return
+	}
 	if !ok {
+		// sent again by the next heartbeat.
+		rf.progress[server].becomeProbe()
 		return
 	}
 
@@ -76,10 +95,12 @@ func (rf *Raft) sendInstallSnapshot(server int) {
 	}
 
 	if reply.CaughtUp {
-		rf.matchIndex[server] = args.LastIncludedIndex
-		rf.nextIndex[server] = args.LastIncludedIndex + 1
+		rf.matchIndex[server] = max(rf.matchIndex[server], args.LastIncludedIndex)
+		rf.nextIndex[server] = rf.matchIndex[server] + 1
+		rf.progress[server].becomeReplicate()
 
 		rf.leaderAppendEntries()
+	} else {
+		rf.progress[server].becomeProbe()
 	}
-
 }
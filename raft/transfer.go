@@ -0,0 +1,98 @@
+package raft
+
+//
+// preferred leaders. each raft has a priority, e.g. higher in the primary data center:
+//   - the higher its priority, the shorter its election timeouts, so it's likely to be the
+//     first candidate once the leader is gone.
+//   - the followers report their priority in their AppendEntries replies. the leader transfers
+//     leadership to the follower of the highest priority above its own, as in §3.10 of the
+//     raft dissertation: it stops taking new entries, so that the follower catches up even
+//     under load, and once it has, tells it with TimeoutNow to start an election at once,
+//     which it wins as its log is up to date. if it doesn't win within an election timeout,
+//     the leader goes on as before, and tries again after transferBackoff.
+//
+
+import "time"
+
+// transferBackoff is the least time between two leadership transfers of a leader.
+const transferBackoff = 5 * time.Second
+
+type TimeoutNowArgs struct {
+	Group int
+	Term  int
+}
+
+type TimeoutNowReply struct {
+	Term int
+}
+
+// TimeoutNow starts an election at once, if it's sent by the leader of the current term.
+func (rf *Raft) TimeoutNow(args *TimeoutNowArgs, reply *TimeoutNowReply) error {
+	rf.mu.Lock()
+	reply.Term = rf.currentTerm
+	start := args.Term == rf.currentTerm && rf.state == Follower
+	rf.mu.Unlock()
+	if start {
+		rf.startElection()
+	}
+	return nil
+}
+
+// transferring reports whether the leader is handing leadership over, and so doesn't take
+// new entries. the caller holds rf.mu.
+func (rf *Raft) transferring() bool {
+	if rf.transferee != -1 && time.Now().After(rf.transferDeadline) {
+		rf.transferee = -1
+	}
+	return rf.transferee != -1
+}
+
+// checkTransfer starts a leadership transfer to the follower of the highest priority above
+// the leader's own, and tells it to stand for election once it has all of the log. the caller
+// holds rf.mu.
+func (rf *Raft) checkTransfer() {
+	if !rf.transferring() {
+		if time.Since(rf.transferTime) < transferBackoff {
+			return
+		}
+		to := -1
+		for peer := range rf.peers {
+			if peer == rf.me || rf.priorities[peer] <= rf.config.Priority || rf.progress[peer].state != Replicate {
+				continue
+			}
+			if to == -1 || rf.priorities[peer] > rf.priorities[to] {
+				to = peer
+			}
+		}
+		if to == -1 {
+			return
+		}
+		rf.transferee = to
+		rf.timeoutSent = false
+		rf.transferTime = time.Now()
+		rf.transferDeadline = rf.transferTime.Add(rf.config.ElectionTimeoutMax)
+	}
+	if rf.timeoutSent || rf.matchIndex[rf.transferee] != rf.log.lastEntry().Index {
+		return
+	}
+	rf.timeoutSent = true
+	args := TimeoutNowArgs{Group: rf.group, Term: rf.currentTerm}
+	go rf.sendTimeoutNow(rf.transferee, &args)
+}
+
+func (rf *Raft) sendTimeoutNow(server int, args *TimeoutNowArgs) {
+	reply := TimeoutNowReply{}
+	ok := rf.call(server, "Raft.TimeoutNow", args, &reply)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if !ok {
+		// take new entries again, rather than wait for the deadline.
+		if rf.currentTerm == args.Term && rf.transferee == server {
+			rf.transferee = -1
+		}
+		return
+	}
+	if reply.Term > rf.currentTerm {
+		rf.becomeFollower(reply.Term)
+	}
+}
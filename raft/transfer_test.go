@@ -0,0 +1,69 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+// transferTo makes the leader of a group of 2 with a log of 2 entries, whose follower of a higher
+// priority is in replicate with only the first of them, and starts handing it leadership.
+func transferTo(t *testing.T) (*Raft, *stalled) {
+	rf, s := connected(t, DefaultConfig, 1, 2, 1, 1)
+	rf.priorities[1] = 1
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 0, Entries: entries(rf, 1, 1)}, &AppendEntriesReply{Term: 1, Success: true, Priority: 1})
+	rf.mu.Lock()
+	rf.checkTransfer()
+	rf.mu.Unlock()
+	return rf, s
+}
+
+// the leader takes no proposals while it hands leadership over, and tells the follower to
+// stand for election only once it has caught up.
+func TestNoProposalsDuringTransfer(t *testing.T) {
+	rf, s := transferTo(t)
+	if rf.transferee != 1 {
+		t.Fatalf("transferee = %d, want 1", rf.transferee)
+	}
+	if _, _, ok := rf.Start("x"); ok {
+		t.Fatal("a proposal was accepted during a transfer")
+	}
+
+	rf.mu.Lock()
+	rf.checkTransfer()
+	rf.mu.Unlock()
+	select {
+	case r := <-s.sent:
+		t.Fatalf("sent %s to a transferee behind the leader", r.name)
+	default:
+	}
+
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 1, Entries: entries(rf, 2, 2)}, &AppendEntriesReply{Term: 1, Success: true, Priority: 1})
+	rf.mu.Lock()
+	rf.checkTransfer()
+	rf.mu.Unlock()
+	if r := s.next(t); r.name != "Raft.TimeoutNow" {
+		t.Fatalf("sent %s to a caught up transferee, want Raft.TimeoutNow", r.name)
+	}
+	if _, _, ok := rf.Start("x"); ok {
+		t.Fatal("a proposal was accepted while the transferee stands for election")
+	}
+}
+
+// a TimeoutNow that fails abandons the transfer, so the leader takes proposals again.
+func TestFailedTimeoutNowEndsTransfer(t *testing.T) {
+	rf, s := transferTo(t)
+	rf.HandleHeartbeat(1, &AppendEntriesArgs{Term: 1, PrevLogIndex: 1, Entries: entries(rf, 2, 2)}, &AppendEntriesReply{Term: 1, Success: true, Priority: 1})
+	rf.mu.Lock()
+	rf.checkTransfer()
+	rf.mu.Unlock()
+	s.next(t).result <- false
+
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, _, ok := rf.Start("x"); ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("no proposal accepted after the TimeoutNow failed")
+		}
+	}
+}
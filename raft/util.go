@@ -1,8 +1,8 @@
 package raft
 
 import (
+	"context"
 	"log"
-	"math/rand"
 	"time"
 )
 
@@ -30,14 +30,26 @@ func min(a int, b int) int {
 	return b
 }
 
-func randTime() time.Duration {
-	randTime := 300 + rand.Int63()%300
-	return time.Duration(randTime) * time.Millisecond
+// an InstallSnapshot carries the whole state, so it's bounded by snapshotTimeout rather
+// than by the RPC timeout of the config.
+const snapshotTimeout = 10 * time.Second
+
+// call sends an RPC to server. it gives up after the RPC timeout, or as soon as the raft is killed.
+func (rf *Raft) call(server int, rpcname string, args interface{}, reply interface{}) bool {
+	return rf.callTimeout(server, rpcname, args, reply, rf.config.RPCTimeout())
+}
+
+func (rf *Raft) callTimeout(server int, rpcname string, args interface{}, reply interface{}, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(rf.ctx, timeout)
+	defer cancel()
+	return rf.peers[server].CallContext(ctx, rpcname, args, reply)
 }
 
 func (rf *Raft) resetElection() {
+	timeout := rf.electionTimeout()
 	rf.electionTimer.Stop()
-	rf.electionTimer.Reset(randTime())
+	rf.electionTimer.Reset(timeout)
+	rf.electionDeadline = time.Now().Add(timeout)
 }
 
 func (rf *Raft) becomeFollower(term int) {
@@ -45,6 +57,7 @@ func (rf *Raft) becomeFollower(term int) {
 	rf.votedFor = -1
 	if term > rf.currentTerm {
 		rf.currentTerm = term
+		rf.leaderId = -1
 	}
 	rf.persist()
 	rf.heartBeatTimer.Stop()
@@ -0,0 +1,88 @@
+package raft
+
+//
+// a witness is a raft that votes and acks entries, but keeps only the term and index of each
+// entry. it never applies the entries, and never stands for election, since it lacks the
+// commands to lead with. two replicas and a witness tolerate the failure of any one of them,
+// at about two thirds of the storage of three replicas.
+//
+// a witness only breaks ties among the replicas:
+//   an entry is committed once a majority of the replicas store it, as if there were no
+//   witness. the leader takes a replica to be down once it hasn't replied for an election
+//   timeout. while no majority of the replicas is up, e.g. one of two, the leader has the
+//   witnesses pin the entries they ack, and an entry stored by half of the replicas and
+//   pinned by a witness is committed too.
+//   a witness votes for a candidate whose log is as up to date as the last entry it pinned,
+//   rather than as its own log, whose tail may not be committed. with one witness and an even
+//   number of replicas, a majority of the votes is either a majority of the replicas, or half
+//   of them and the witness. so the survivor of two replicas is elected with the witness,
+//   unless the other one committed entries by the witness while the survivor was down.
+// the leader learns which followers are witnesses from their replies, and then sends them
+// the entries without their commands, and its snapshots without their data.
+//
+
+import "time"
+
+// witnessLog is the number of applied entries after which a witness compacts its log.
+const witnessLog = 1000
+
+// stripped drops the commands of entries, which a witness doesn't keep.
+func stripped(entries []Entry) []Entry {
+	for i := range entries {
+		entries[i].Command = nil
+	}
+	return entries
+}
+
+// skipApplied marks the committed entries of a witness applied, without applying them,
+// and compacts its log once it has grown by witnessLog entries. the caller holds rf.mu.
+func (rf *Raft) skipApplied() {
+	rf.lastApplied = rf.commitIndex
+	if rf.lastApplied-rf.log.FirstIndex < witnessLog {
+		return
+	}
+	term := rf.log.at(rf.lastApplied).Term
+	rf.log.compactedTo(rf.lastApplied, term)
+	rf.snapshot = Snapshot{Term: term, Index: rf.lastApplied}
+	rf.persist()
+}
+
+// replicasUp returns whether a majority of the replicas, the leader included, replied within
+// an election timeout, so that the entries can be committed without the witnesses.
+// the caller holds rf.mu.
+func (rf *Raft) replicasUp() bool {
+	replicas, up := 0, 0
+	for peer := range rf.peers {
+		if rf.witnesses[peer] {
+			continue
+		}
+		replicas++
+		if peer == rf.me || time.Since(rf.progress[peer].replied) < rf.config.ElectionTimeoutMax {
+			up++
+		}
+	}
+	return up*2 > replicas
+}
+
+// pin pins the entry at index, unless a later one is pinned already. the caller holds rf.mu.
+func (rf *Raft) pin(index int) {
+	if index < rf.log.FirstIndex {
+		// compacted, hence committed.
+		return
+	}
+	term := rf.log.at(index).Term
+	if term > rf.pinnedTerm || (term == rf.pinnedTerm && index > rf.pinnedIndex) {
+		rf.pinnedTerm = term
+		rf.pinnedIndex = index
+		rf.persist()
+	}
+}
+
+// unpin lowers the pin to index, once the entries after it are overwritten.
+// the caller holds rf.mu.
+func (rf *Raft) unpin(index int) {
+	if rf.pinnedIndex > index {
+		rf.pinnedTerm = rf.log.at(index).Term
+		rf.pinnedIndex = index
+	}
+}
@@ -0,0 +1,129 @@
+package secure
+
+//
+// mutual TLS for all the RPCs of a process. once Setup is called, every
+// listener requires a client certificate signed by the cluster CA, and every
+// call to a peer verifies the peer's certificate against it.
+//
+// the RPCs that only cluster members may call, i.e. those of Raft and of the
+// shard migration, are served to a caller only if its certificate is valid
+// for the IP of one of the members allowed to, as known to the server.
+//
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+
+	"DDB/client"
+)
+
+var serverConfig *tls.Config
+
+// Setup loads the CA certificate and the certificate and key of this process.
+func Setup(caFile string, certFile string, keyFile string) error {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errors.New("no certificate found in " + caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	serverConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	client.UseTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	return nil
+}
+
+func Enabled() bool {
+	return serverConfig != nil
+}
+
+// ServerConfig returns the TLS config of the listeners, or nil before Setup.
+func ServerConfig() *tls.Config {
+	if serverConfig == nil {
+		return nil
+	}
+	return serverConfig.Clone()
+}
+
+// Listen listens on port, over TLS once Setup has been called.
+func Listen(port string) (net.Listener, error) {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil || serverConfig == nil {
+		return l, err
+	}
+	return tls.NewListener(l, serverConfig), nil
+}
+
+// Tier is the RPCs served to some of the cluster members, those whose addresses,
+// as "ip:port", Members returns.
+type Tier struct {
+	Server  *rpc.Server
+	Members func() []string
+}
+
+// Handler serves the RPCs of a caller with the first of tiers it's a member of, or else with clients.
+// without TLS, there's no way to tell members apart, and everyone is served with the first tier.
+func Handler(clients *rpc.Server, tiers ...Tier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			tiers[0].Server.ServeHTTP(w, r)
+			return
+		}
+		for _, tier := range tiers {
+			if isMember(r.TLS.PeerCertificates, tier.Members()) {
+				tier.Server.ServeHTTP(w, r)
+				return
+			}
+		}
+		clients.ServeHTTP(w, r)
+	})
+}
+
+// isMember tells whether the verified certificate of the caller is valid for one of the members.
+func isMember(certs []*x509.Certificate, members []string) bool {
+	if len(certs) == 0 {
+		return false
+	}
+	for _, member := range members {
+		host, _, err := net.SplitHostPort(member)
+		if err != nil {
+			host = member
+		}
+		if certs[0].VerifyHostname(host) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve serves handler on port, under the default RPC path.
+func Serve(port string, handler http.Handler) error {
+	l, err := Listen(port)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, handler)
+	go http.Serve(l, mux)
+	return nil
+}
@@ -0,0 +1,147 @@
+package shardctrler
+
+//
+// Shardctrler clerk.
+//
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"DDB/client"
+)
+
+// callTimeout bounds a single RPC to a controller server.
+const callTimeout = 1 * time.Second
+
+type Clerk struct {
+	servers []*client.Client
+	id      int64
+	leader  int
+	opId    int
+	policy  client.RetryPolicy
+}
+
+func nrand() int64 {
+	max := big.NewInt(int64(1) << 62)
+	bigx, _ := rand.Int(rand.Reader, max)
+	x := bigx.Int64()
+	return x
+}
+
+func MakeClerk(servers []*client.Client) *Clerk {
+	ck := new(Clerk)
+	ck.servers = servers
+	ck.id = nrand()
+	ck.leader = 0
+	ck.opId = 0
+	ck.policy = client.DefaultRetryPolicy
+	return ck
+}
+
+// SetRetryPolicy sets how the later ops of the clerk are retried while no controller
+// server gets them done.
+func (ck *Clerk) SetRetryPolicy(policy client.RetryPolicy) {
+	ck.policy = policy
+}
+
+func (ck *Clerk) allocateOpId() int {
+	opId := ck.opId
+	ck.opId++
+	return opId
+}
+
+// call sends the rpc to the controller servers until the leader gets it done, ctx is done or
+// the retry policy gives up.
+// reply must make a new reply for each attempt and return the Err it carries. the reply
+// accepted is returned, since an abandoned attempt may still be writing to its own.
+func (ck *Clerk) call(ctx context.Context, rpcname string, args interface{}, reply func() (interface{}, *Err)) (interface{}, error) {
+	retry := client.NewRetrier(ck.policy)
+	for {
+		for i := range ck.servers {
+			serverId := (ck.leader + i) % len(ck.servers)
+			r, err := reply()
+			callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+			ok := ck.servers[serverId].CallContext(callCtx, rpcname, args, r)
+			cancel()
+			if ok && *err != ErrWrongLeader && *err != ErrNotApplied {
+				ck.leader = serverId
+				return r, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+		if err := retry.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (ck *Clerk) Query(num int) Config {
+	config, _ := ck.QueryContext(context.Background(), num)
+	return config
+}
+
+func (ck *Clerk) QueryContext(ctx context.Context, num int) (Config, error) {
+	args := QueryArgs{Num: num, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	r, err := ck.call(ctx, "ShardCtrler.Query", &args, func() (interface{}, *Err) {
+		reply := QueryReply{}
+		return &reply, &reply.Err
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	return r.(*QueryReply).Config, nil
+}
+
+func (ck *Clerk) Join(servers map[int][]string) {
+	args := JoinArgs{Servers: servers, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	ck.call(context.Background(), "ShardCtrler.Join", &args, func() (interface{}, *Err) {
+		reply := JoinReply{}
+		return &reply, &reply.Err
+	})
+}
+
+func (ck *Clerk) Leave(gids []int) {
+	args := LeaveArgs{GIDs: gids, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	ck.call(context.Background(), "ShardCtrler.Leave", &args, func() (interface{}, *Err) {
+		reply := LeaveReply{}
+		return &reply, &reply.Err
+	})
+}
+
+func (ck *Clerk) Move(shard int, gid int) {
+	args := MoveArgs{Shard: shard, GID: gid, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	ck.call(context.Background(), "ShardCtrler.Move", &args, func() (interface{}, *Err) {
+		reply := MoveReply{}
+		return &reply, &reply.Err
+	})
+}
+
+func (ck *Clerk) Split(shard int, key string) {
+	args := SplitArgs{Shard: shard, Key: key, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	ck.call(context.Background(), "ShardCtrler.Split", &args, func() (interface{}, *Err) {
+		reply := SplitReply{}
+		return &reply, &reply.Err
+	})
+}
+
+// Auth applies the auth op to the users as the holder of token, see AuthArgs. it returns
+// the Err of the op if it failed, or the error of the retries.
+func (ck *Clerk) Auth(token string, op string, name string, field string, value string) error {
+	args := AuthArgs{Op: op, Name: name, Field: field, Value: value, Token: token, OpId: ck.allocateOpId(), ClerkId: ck.id}
+	r, err := ck.call(context.Background(), "ShardCtrler.Auth", &args, func() (interface{}, *Err) {
+		reply := AuthReply{}
+		return &reply, &reply.Err
+	})
+	if err != nil {
+		return err
+	}
+	if e := r.(*AuthReply).Err; e != OK {
+		return e
+	}
+	return nil
+}
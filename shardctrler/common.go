@@ -0,0 +1,183 @@
+package shardctrler
+
+import (
+	"sort"
+
+	"DDB/auth"
+)
+
+//
+// Shard controller: assigns shards to replication groups.
+//
+// RPC interface:
+// Join(servers) -- add a set of groups (gid -> server-list mapping).
+// Leave(gids) -- delete a set of groups.
+// Move(shard, gid) -- hand off one shard from current owner to gid.
+// Split(shard, key) -- split a shard in two at key.
+// Query(num) -> fetch Config # num, or latest config if num==-1.
+// Auth(op) -- change the users, see the auth package.
+//
+// A Config (configuration) describes a set of replica groups, and the
+// replica group responsible for each shard. Configs are numbered. Config
+// #0 is the initial configuration, with no groups and a single shard
+// covering all keys, assigned to group 0 (the invalid group).
+//
+// Shards are ranges of keys. They are never merged, and they only grow in
+// number by splits, so the configs also make up the metadata of every split
+// that has ever happened.
+//
+// The users of the KV groups are kept here too, so that each config carries
+// the users in force, and a group enforces them as soon as it serves shards.
+//
+
+// A shard -- the keys from Start up to the Start of the next range.
+type KeyRange struct {
+	Id    int    // shard id, kept across splits by the lower half.
+	Start string // inclusive.
+	Gid   int
+}
+
+// A configuration -- an assignment of shards to groups.
+type Config struct {
+	Num    int              // config number
+	Ranges []KeyRange       // sorted by Start, the first one starting at "".
+	Groups map[int][]string // gid -> servers[], each as "ip:port"
+	Auth   *auth.Store      // the users, never changed once in a config.
+}
+
+const (
+	OK             = "OK"
+	ErrWrongLeader = "ErrWrongLeader"
+	ErrNotApplied  = "ErrNotApplied"
+
+	// the errors of the auth ops, named as those of the KV servers.
+	ErrUnauthenticated  = "ErrUnauthenticated"
+	ErrPermissionDenied = "ErrPermissionDenied"
+	ErrNoKey            = "ErrNoKey"     // no such user.
+	ErrUnknownOp        = "ErrUnknownOp" // no such op or permission.
+)
+
+type Err string
+
+func (e Err) Error() string {
+	return string(e)
+}
+
+type JoinArgs struct {
+	Servers map[int][]string // new GID -> servers mappings
+	OpId    int
+	ClerkId int64
+}
+
+type JoinReply struct {
+	Err Err
+}
+
+type LeaveArgs struct {
+	GIDs    []int
+	OpId    int
+	ClerkId int64
+}
+
+type LeaveReply struct {
+	Err Err
+}
+
+type MoveArgs struct {
+	Shard   int
+	GID     int
+	OpId    int
+	ClerkId int64
+}
+
+type MoveReply struct {
+	Err Err
+}
+
+type SplitArgs struct {
+	Shard   int
+	Key     string
+	OpId    int
+	ClerkId int64
+}
+
+type SplitReply struct {
+	Err Err
+}
+
+type AuthArgs struct {
+	Op      string // "AddUser", "DeleteUser", "GrantRole", "RevokeRole" or "SetPermission"
+	Name    string // the user, or the role for SetPermission.
+	Field   string
+	Value   string
+	Token   string // "user:secret", if authentication is on.
+	OpId    int
+	ClerkId int64
+}
+
+type AuthReply struct {
+	Err Err
+}
+
+type QueryArgs struct {
+	Num     int // desired config number
+	OpId    int
+	ClerkId int64
+}
+
+type QueryReply struct {
+	Err    Err
+	Config Config
+}
+
+// Shard returns the shard that key belongs to under this config.
+func (cfg *Config) Shard(key string) int {
+	i := sort.Search(len(cfg.Ranges), func(i int) bool {
+		return cfg.Ranges[i].Start > key
+	})
+	if i == 0 {
+		// no range, i.e. the zero config.
+		return 0
+	}
+	return cfg.Ranges[i-1].Id
+}
+
+// Owner returns the gid in charge of shard, or 0 if none is.
+func (cfg *Config) Owner(shard int) int {
+	if r := cfg.find(shard); r != nil {
+		return r.Gid
+	}
+	return 0
+}
+
+// Bounds returns the range [start, end) of keys of shard.
+// end is empty for the last shard, which has no upper bound.
+func (cfg *Config) Bounds(shard int) (string, string) {
+	for i := range cfg.Ranges {
+		if cfg.Ranges[i].Id == shard {
+			if i+1 < len(cfg.Ranges) {
+				return cfg.Ranges[i].Start, cfg.Ranges[i+1].Start
+			}
+			return cfg.Ranges[i].Start, ""
+		}
+	}
+	return "", ""
+}
+
+func (cfg *Config) find(shard int) *KeyRange {
+	for i := range cfg.Ranges {
+		if cfg.Ranges[i].Id == shard {
+			return &cfg.Ranges[i]
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) Copy() Config {
+	// note: the users are shared, since they're never changed once in a config.
+	copied := Config{Num: cfg.Num, Ranges: append([]KeyRange{}, cfg.Ranges...), Groups: make(map[int][]string), Auth: cfg.Auth}
+	for gid, servers := range cfg.Groups {
+		copied.Groups[gid] = append([]string{}, servers...)
+	}
+	return copied
+}
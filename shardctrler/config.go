@@ -0,0 +1,107 @@
+package shardctrler
+
+import "sort"
+
+// rebalance moves as few shards as possible so that every group holds either
+// len(Ranges)/len(Groups) or one more shard. the result must be deterministic since
+// every replica of the controller runs it on its own.
+func (cfg *Config) rebalance() {
+	if len(cfg.Groups) == 0 {
+		for i := range cfg.Ranges {
+			cfg.Ranges[i].Gid = 0
+		}
+		return
+	}
+
+	gids := make([]int, 0, len(cfg.Groups))
+	for gid := range cfg.Groups {
+		gids = append(gids, gid)
+	}
+	sort.Ints(gids)
+
+	owned := make(map[int][]int) // gid -> indices into Ranges.
+	orphans := []int{}
+	for i, r := range cfg.Ranges {
+		if _, ok := cfg.Groups[r.Gid]; ok {
+			owned[r.Gid] = append(owned[r.Gid], i)
+		} else {
+			orphans = append(orphans, i)
+		}
+	}
+
+	// groups holding more shards hand over their surplus first.
+	sort.SliceStable(gids, func(i, j int) bool {
+		return len(owned[gids[i]]) > len(owned[gids[j]])
+	})
+	base := len(cfg.Ranges) / len(gids)
+	extra := len(cfg.Ranges) % len(gids)
+	target := make(map[int]int)
+	for i, gid := range gids {
+		target[gid] = base
+		if i < extra {
+			target[gid]++
+		}
+	}
+
+	for _, gid := range gids {
+		for len(owned[gid]) > target[gid] {
+			last := len(owned[gid]) - 1
+			orphans = append(orphans, owned[gid][last])
+			owned[gid] = owned[gid][:last]
+		}
+	}
+	sort.Ints(orphans)
+	for _, gid := range gids {
+		for len(owned[gid]) < target[gid] && len(orphans) > 0 {
+			cfg.Ranges[orphans[0]].Gid = gid
+			owned[gid] = append(owned[gid], orphans[0])
+			orphans = orphans[1:]
+		}
+	}
+}
+
+func (cfg *Config) join(servers map[int][]string) {
+	for gid, group := range servers {
+		cfg.Groups[gid] = append([]string{}, group...)
+	}
+	cfg.rebalance()
+}
+
+func (cfg *Config) leave(gids []int) {
+	for _, gid := range gids {
+		delete(cfg.Groups, gid)
+	}
+	cfg.rebalance()
+}
+
+func (cfg *Config) move(shard int, gid int) {
+	if r := cfg.find(shard); r != nil {
+		r.Gid = gid
+	}
+}
+
+// split cuts shard at key. the upper half becomes a new shard, first owned by the same group,
+// and is then free to be moved by the rebalancing.
+func (cfg *Config) split(shard int, key string) {
+	start, end := cfg.Bounds(shard)
+	r := cfg.find(shard)
+	if r == nil || key <= start || (end != "" && key >= end) {
+		return
+	}
+	id := 0
+	for _, r := range cfg.Ranges {
+		id = max(id, r.Id+1)
+	}
+	cfg.Ranges = append(cfg.Ranges, KeyRange{Id: id, Start: key, Gid: r.Gid})
+	sort.Slice(cfg.Ranges, func(i, j int) bool {
+		return cfg.Ranges[i].Start < cfg.Ranges[j].Start
+	})
+	cfg.rebalance()
+}
+
+func max(a int, b int) int {
+	if a >= b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,279 @@
+package shardctrler
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"net/rpc"
+
+	"DDB/auth"
+	"DDB/client"
+	"DDB/labgob"
+	"DDB/raft"
+	"DDB/transport"
+)
+
+const maxWaitTime = 500 * time.Millisecond
+
+type ShardCtrler struct {
+	mu      sync.Mutex
+	me      int
+	rf      *raft.Raft
+	applyCh chan raft.ApplyMsg
+	dead    int32 // set by Kill()
+
+	configs    []Config // indexed by config num
+	maxApplied map[int64]int
+	authErrs   map[int64]Err // clerk id -> the result of the last auth op of the clerk.
+	applied    *sync.Cond
+
+	transport transport.Transport
+	port      string // the address served on.
+}
+
+type Op struct {
+	// Field names must start with capital letters,
+	// otherwise RPC will break.
+	Type    string // "Join", "Leave", "Move", "Split", "Query" or an auth op.
+	Servers map[int][]string
+	GIDs    []int
+	Shard   int
+	GID     int
+	Key     string
+	Field   string
+	Value   string
+	Num     int
+	ClerkId int64
+	OpId    int
+}
+
+func (sc *ShardCtrler) Join(args *JoinArgs, reply *JoinReply) error {
+	op := Op{Type: "Join", Servers: args.Servers, ClerkId: args.ClerkId, OpId: args.OpId}
+	reply.Err, _ = sc.waitApply(&op)
+	return nil
+}
+
+func (sc *ShardCtrler) Leave(args *LeaveArgs, reply *LeaveReply) error {
+	op := Op{Type: "Leave", GIDs: args.GIDs, ClerkId: args.ClerkId, OpId: args.OpId}
+	reply.Err, _ = sc.waitApply(&op)
+	return nil
+}
+
+func (sc *ShardCtrler) Move(args *MoveArgs, reply *MoveReply) error {
+	op := Op{Type: "Move", Shard: args.Shard, GID: args.GID, ClerkId: args.ClerkId, OpId: args.OpId}
+	reply.Err, _ = sc.waitApply(&op)
+	return nil
+}
+
+func (sc *ShardCtrler) Split(args *SplitArgs, reply *SplitReply) error {
+	op := Op{Type: "Split", Shard: args.Shard, Key: args.Key, ClerkId: args.ClerkId, OpId: args.OpId}
+	reply.Err, _ = sc.waitApply(&op)
+	return nil
+}
+
+func (sc *ShardCtrler) Query(args *QueryArgs, reply *QueryReply) error {
+	op := Op{Type: "Query", Num: args.Num, ClerkId: args.ClerkId, OpId: args.OpId}
+	reply.Err, reply.Config = sc.waitApply(&op)
+	return nil
+}
+
+// Auth applies an auth op to the users, which takes the admin permission on all keys.
+func (sc *ShardCtrler) Auth(args *AuthArgs, reply *AuthReply) error {
+	if !auth.IsOp(args.Op) {
+		reply.Err = ErrUnknownOp
+		return nil
+	}
+	sc.mu.Lock()
+	reply.Err = authErr(sc.configs[len(sc.configs)-1].Auth.Authorize(args.Token, "", auth.Admin))
+	sc.mu.Unlock()
+	if reply.Err != OK {
+		return nil
+	}
+	op := Op{Type: args.Op, Key: args.Name, Field: args.Field, Value: args.Value, ClerkId: args.ClerkId, OpId: args.OpId}
+	if op.Type == "AddUser" {
+		// note: only the hash of a secret is ever replicated.
+		op.Value = auth.HashSecret(op.Value)
+	}
+	reply.Err, _ = sc.waitApply(&op)
+	if reply.Err == OK {
+		sc.mu.Lock()
+		reply.Err = sc.authErrs[args.ClerkId]
+		sc.mu.Unlock()
+	}
+	return nil
+}
+
+// authErr maps an error of the auth package to the Err of a reply.
+func authErr(e error) Err {
+	switch e {
+	case nil:
+		return OK
+	case auth.ErrUnauthenticated:
+		return ErrUnauthenticated
+	case auth.ErrPermissionDenied:
+		return ErrPermissionDenied
+	case auth.ErrNoUser:
+		return ErrNoKey
+	}
+	return ErrUnknownOp
+}
+
+func (sc *ShardCtrler) isApplied(op *Op) bool {
+	max, ok := sc.maxApplied[op.ClerkId]
+	return ok && max >= op.OpId
+}
+
+func (sc *ShardCtrler) query(num int) Config {
+	if num < 0 || num >= len(sc.configs) {
+		num = len(sc.configs) - 1
+	}
+	return sc.configs[num].Copy()
+}
+
+func (sc *ShardCtrler) waitApply(op *Op) (Err, Config) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.isApplied(op) {
+		if _, _, isLeader := sc.rf.Start(op); !isLeader {
+			return ErrWrongLeader, Config{}
+		}
+
+		// wait until applied or timeout.
+		deadline := time.Now().Add(maxWaitTime)
+		go func() {
+			<-time.After(maxWaitTime)
+			sc.mu.Lock()
+			defer sc.mu.Unlock()
+			sc.applied.Broadcast()
+		}()
+		for !sc.isApplied(op) && !sc.Killed() && time.Now().Before(deadline) {
+			sc.applied.Wait()
+		}
+	}
+
+	if sc.isApplied(op) {
+		// note: a query may observe configs newer than the one at its log index, which is
+		// harmless since configs are immutable once created.
+		return OK, sc.query(op.Num)
+	}
+	return ErrNotApplied, Config{}
+}
+
+func (sc *ShardCtrler) apply(op *Op) {
+	if sc.isApplied(op) {
+		return
+	}
+	if auth.IsOp(op.Type) {
+		// a failed op makes no new config.
+		config := sc.query(-1)
+		config.Num++
+		config.Auth = config.Auth.Copy()
+		sc.authErrs[op.ClerkId] = authErr(config.Auth.Apply(op.Type, op.Key, op.Field, op.Value))
+		if sc.authErrs[op.ClerkId] == OK {
+			sc.configs = append(sc.configs, config)
+		}
+	} else if op.Type != "Query" {
+		config := sc.query(-1)
+		config.Num++
+		switch op.Type {
+		case "Join":
+			config.join(op.Servers)
+		case "Leave":
+			config.leave(op.GIDs)
+		case "Move":
+			config.move(op.Shard, op.GID)
+		case "Split":
+			config.split(op.Shard, op.Key)
+		}
+		sc.configs = append(sc.configs, config)
+	}
+	sc.maxApplied[op.ClerkId] = op.OpId
+	sc.applied.Broadcast()
+}
+
+func (sc *ShardCtrler) applier() {
+	for m := range sc.applyCh {
+		if sc.Killed() {
+			return
+		}
+		if m.CommandValid {
+			sc.mu.Lock()
+			sc.apply(m.Command.(*Op))
+			sc.mu.Unlock()
+		}
+	}
+}
+
+// the servers[] contain the ports of the set of
+// servers that will cooperate via Raft to
+// form the fault-tolerant shard controller service.
+func StartServer(servers []*client.Client, me int, persister *raft.Persister, port string) *ShardCtrler {
+	return StartServerOn(transport.TCP, servers, me, persister, port)
+}
+
+// StartServerOn is StartServer over the transport t, on which the server serves at address.
+func StartServerOn(t transport.Transport, servers []*client.Client, me int, persister *raft.Persister, address string) *ShardCtrler {
+	labgob.Register(&Op{})
+
+	sc := new(ShardCtrler)
+	sc.me = me
+	sc.transport = t
+	sc.port = address
+	servers = transport.Redial(t, servers)
+
+	sc.configs = make([]Config, 1)
+	sc.configs[0].Ranges = []KeyRange{{Id: 0, Start: "", Gid: 0}}
+	sc.configs[0].Groups = map[int][]string{}
+	sc.configs[0].Auth = auth.MakeStore()
+	sc.maxApplied = make(map[int64]int)
+	sc.authErrs = make(map[int64]Err)
+	sc.applied = sync.NewCond(&sc.mu)
+
+	sc.applyCh = make(chan raft.ApplyMsg)
+	sc.rf = raft.Make(servers, me, persister, sc.applyCh, raft.DefaultConfig)
+
+	go sc.applier()
+
+	sc.server(sc.rf)
+
+	return sc
+}
+
+// server serves the controller RPCs to everyone, and the raft RPCs only to the controller servers.
+// note: the KV groups are clients of the controller.
+func (sc *ShardCtrler) server(rf *raft.Raft) {
+	peers := rpc.NewServer()
+	if peers.Register(sc) != nil {
+		log.Fatal("error")
+	}
+	if peers.Register(rf) != nil {
+		log.Fatal("error")
+	}
+	clients := rpc.NewServer()
+	if clients.Register(sc) != nil {
+		log.Fatal("error")
+	}
+	members := func() []string {
+		addresses := []string{}
+		for _, peer := range rf.Peers() {
+			addresses = append(addresses, peer.Address())
+		}
+		return addresses
+	}
+	if e := sc.transport.Serve(sc.port, clients, transport.Tier{Server: peers, Members: members}); e != nil {
+		log.Fatal(e)
+	}
+}
+
+func (sc *ShardCtrler) Kill() {
+	atomic.StoreInt32(&sc.dead, 1)
+	sc.rf.Kill()
+}
+
+func (sc *ShardCtrler) Killed() bool {
+	z := atomic.LoadInt32(&sc.dead)
+	return z == 1
+}
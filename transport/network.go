@@ -0,0 +1,268 @@
+package transport
+
+//
+// Network simulates, in one process, the network between the nodes of a cluster,
+// in the manner of the labrpc package of 6.5840. it can drop, delay and reorder
+// messages, and cut nodes off or partition them.
+//
+// each node is given the transport From(name), where name is the address it serves on:
+//   net := transport.MakeNetwork()
+//   servers := []*client.Client{net.Dial("s0"), net.Dial("s1"), net.Dial("s2")}
+//   kv := kvraft.StartShardKVServerOn(net.From("s0"), servers, 0, raft.MakePersister(), raft.DefaultConfig, "s0", 0, nil)
+//   ck := kvraft.MakeClerk(servers)
+// calls made over the Network itself come from an anonymous client.
+//
+// args and replies are copied through gob, as with net/rpc, so no memory is shared.
+//
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"DDB/client"
+)
+
+type Network struct {
+	mu             sync.Mutex
+	reliable       bool
+	longDelays     bool // calls to unreachable servers take long to fail.
+	longReordering bool // replies are sometimes delayed for long.
+	servers        map[string]*server
+	disconnected   map[string]bool
+	sides          map[string]int // name -> side of the partition.
+	count          int            // total number of calls.
+}
+
+type server struct {
+	clients *rpc.Server
+	tiers   []Tier
+}
+
+func MakeNetwork() *Network {
+	n := new(Network)
+	n.reliable = true
+	n.servers = make(map[string]*server)
+	n.disconnected = make(map[string]bool)
+	n.sides = make(map[string]int)
+	return n
+}
+
+// Reliable sets whether messages are delivered without loss nor delay.
+func (n *Network) Reliable(yes bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reliable = yes
+}
+
+func (n *Network) LongDelays(yes bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.longDelays = yes
+}
+
+func (n *Network) LongReordering(yes bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.longReordering = yes
+}
+
+// Disconnect cuts the node name off: it can neither call nor be called anymore.
+func (n *Network) Disconnect(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[name] = true
+}
+
+func (n *Network) Connect(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.disconnected, name)
+}
+
+// Partition splits the given nodes into sides that can only reach the nodes of their own side.
+// the nodes not given, such as the clients, reach every side. Partition() heals the network.
+func (n *Network) Partition(sides ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sides = make(map[string]int)
+	for side, names := range sides {
+		for _, name := range names {
+			n.sides[name] = side
+		}
+	}
+}
+
+// Count returns the number of calls made so far.
+func (n *Network) Count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.count
+}
+
+// From returns the transport of the node name.
+func (n *Network) From(name string) Transport {
+	return &endpoint{n, name}
+}
+
+func (n *Network) Dial(address string) *client.Client {
+	return n.From("").Dial(address)
+}
+
+func (n *Network) Serve(address string, clients *rpc.Server, tiers ...Tier) error {
+	return n.From("").Serve(address, clients, tiers...)
+}
+
+func (n *Network) reachable(from string, to string) bool {
+	if n.disconnected[from] || n.disconnected[to] {
+		return false
+	}
+	a, ok1 := n.sides[from]
+	b, ok2 := n.sides[to]
+	return !ok1 || !ok2 || a == b
+}
+
+// endpoint is the transport of a node, through which its calls go.
+type endpoint struct {
+	n    *Network
+	name string
+}
+
+func (e *endpoint) Dial(address string) *client.Client {
+	return client.MakeClientVia(e, address)
+}
+
+// Serve registers the server at address, replacing any previous one, e.g. when it restarts.
+func (e *endpoint) Serve(address string, clients *rpc.Server, tiers ...Tier) error {
+	e.n.mu.Lock()
+	defer e.n.mu.Unlock()
+	e.n.servers[address] = &server{clients, tiers}
+	return nil
+}
+
+func (e *endpoint) Call(ctx context.Context, address string, rpcname string, args interface{}, reply interface{}) bool {
+	return e.n.call(ctx, e.name, address, rpcname, args, reply)
+}
+
+func (n *Network) call(ctx context.Context, from string, to string, rpcname string, args interface{}, reply interface{}) bool {
+	n.mu.Lock()
+	n.count++
+	reliable, longDelays, longReordering := n.reliable, n.longDelays, n.longReordering
+	s := n.servers[to]
+	reachable := s != nil && n.reachable(from, to)
+	n.mu.Unlock()
+
+	if !reliable {
+		// a short delay, and the request may be lost.
+		if !sleep(ctx, time.Duration(rand.Intn(27))*time.Millisecond) || rand.Intn(1000) < 100 {
+			return false
+		}
+	}
+	if !reachable {
+		// no reply comes back, as if the caller timed out.
+		timeout := 100
+		if longDelays {
+			timeout = 7000
+		}
+		sleep(ctx, time.Duration(rand.Intn(timeout))*time.Millisecond)
+		return false
+	}
+
+	var buf bytes.Buffer
+	if gob.NewEncoder(&buf).Encode(args) != nil {
+		return false
+	}
+	c := &codec{method: rpcname, args: buf.Bytes()}
+	handler := s.clients
+	for _, tier := range s.tiers {
+		if from != "" && contains(tier.Members(), from) {
+			handler = tier.Server
+			break
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		handler.ServeRequest(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return false
+	}
+
+	// the reply is lost if the server was cut off or replaced while handling the call.
+	n.mu.Lock()
+	reachable = n.servers[to] == s && n.reachable(from, to)
+	n.mu.Unlock()
+	if !reachable || c.err != "" {
+		return false
+	}
+	if !reliable && rand.Intn(1000) < 100 {
+		return false
+	}
+	if longReordering && rand.Intn(900) < 600 {
+		// delay the reply so that it comes after the replies of later calls.
+		if !sleep(ctx, time.Duration(200+rand.Intn(1+rand.Intn(2000)))*time.Millisecond) {
+			return false
+		}
+	}
+	return gob.NewDecoder(bytes.NewReader(c.reply.Bytes())).Decode(reply) == nil
+}
+
+// sleep waits for d, and tells whether ctx is still alive.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// codec hands a single call to an rpc.Server, and keeps its reply.
+type codec struct {
+	method string
+	args   []byte
+	reply  bytes.Buffer
+	err    string
+}
+
+func (c *codec) ReadRequestHeader(r *rpc.Request) error {
+	r.ServiceMethod = c.method
+	return nil
+}
+
+func (c *codec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(c.args)).Decode(body)
+}
+
+func (c *codec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.err = r.Error
+	if r.Error != "" {
+		return nil
+	}
+	return gob.NewEncoder(&c.reply).Encode(body)
+}
+
+func (c *codec) Close() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package transport
+
+//
+// a transport carries the RPCs between the clients and the servers of a cluster:
+// TCP in production, or an in-process simulated Network to run whole clusters in one test.
+//
+
+import (
+	"net/rpc"
+
+	"DDB/client"
+	"DDB/secure"
+)
+
+type Transport interface {
+	// Dial makes a client for the server at address.
+	Dial(address string) *client.Client
+
+	// Serve serves the RPCs sent to address: those of a cluster member with the first of
+	// tiers it's a member of, and those of anyone else with clients.
+	Serve(address string, clients *rpc.Server, tiers ...Tier) error
+}
+
+// Tier is the RPCs served to some of the cluster members, e.g. to the peers of a raft group.
+type Tier = secure.Tier
+
+// TCP serves on the port given as address, over TLS once secure.Setup has been called.
+var TCP Transport = tcp{}
+
+type tcp struct{}
+
+func (tcp) Dial(address string) *client.Client {
+	return client.MakeClientFromAddress(address)
+}
+
+func (tcp) Serve(port string, clients *rpc.Server, tiers ...Tier) error {
+	return secure.Serve(port, secure.Handler(clients, tiers...))
+}
+
+// Redial makes clients for the addresses of clients over t, e.g. so that a server
+// calls its peers from its own address.
+func Redial(t Transport, clients []*client.Client) []*client.Client {
+	redialed := make([]*client.Client, len(clients))
+	for i, cl := range clients {
+		redialed[i] = t.Dial(cl.Address())
+	}
+	return redialed
+}